@@ -1,87 +1,440 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 func main() {
 	var rootCmd = &cobra.Command{
-		Use:   "file-inventory",
-		Short: "A tool for creating and comparing file inventories",
-		Long:  "file-inventory helps you create file inventories and compare them to track changes in directories.",
+		Use:     "file-inventory",
+		Short:   "A tool for creating and comparing file inventories",
+		Long:    "file-inventory helps you create file inventories and compare them to track changes in directories.",
+		Version: version,
 	}
 
 	// Global config variables
 	var (
-		output          string
-		sortOutput      bool
-		fullPaths       bool
-		includeHidden   bool
-		excludePatterns []string
-		includePatterns []string
+		output           string
+		sortOutput       bool
+		fullPaths        bool
+		includeHidden    bool
+		excludePatterns  []string
+		includePatterns  []string
+		attributes       []string
+		hashAlgorithm    string
+		jobs             int
+		format           string
+		diffFormat       string
+		quiet            bool
+		respectGitignore bool
+		walkers          int
+		renameHeuristic  bool
+		sortMemory       string
+		symlinks         string
+		dirs             bool
+		minSize          string
+		maxSize          string
+		newer            string
+		older            string
+		timeField        string
+		fileTypes        []string
+		reuse            string
+		noHeader         bool
 	)
 
+	// scanConfig builds the Config shared by create and watch from their flags
+	scanConfig := func() (Config, error) {
+		types, err := parseFileTypes(fileTypes)
+		if err != nil {
+			return Config{}, err
+		}
+		if len(types) > 0 {
+			// Say what each entry is when only some types are listed
+			attributes = append(attributes, AttrType)
+		}
+		attrs, err := parseAttributes(attributes)
+		if err != nil {
+			return Config{}, err
+		}
+		if err := validateHashAlgorithm(hashAlgorithm); err != nil {
+			return Config{}, err
+		}
+		if err := validateInventoryFormat(format); err != nil {
+			return Config{}, err
+		}
+		if err := validateSymlinkMode(symlinks); err != nil {
+			return Config{}, err
+		}
+		config := Config{
+			RelativePaths:    !fullPaths, // Default to relative paths unless --full is specified
+			IncludeHidden:    includeHidden,
+			ExcludePatterns:  excludePatterns,
+			IncludePatterns:  includePatterns,
+			Attributes:       attrs,
+			HashAlgorithm:    hashAlgorithm,
+			Jobs:             jobs,
+			Format:           format,
+			RespectGitignore: respectGitignore,
+			Symlinks:         symlinks,
+			Dirs:             dirs || containsString(types, "d"),
+			Types:            types,
+			TimeField:        timeField,
+			Header:           !noHeader,
+		}
+		if err := parsePredicateFlags(&config, minSize, maxSize, newer, older); err != nil {
+			return Config{}, err
+		}
+		return config, nil
+	}
+
 	var createCmd = &cobra.Command{
-		Use:   "create [DIR]",
-		Short: "Create a file inventory for a directory",
-		Long:  "Recursively scan a directory and create a text file listing all files found.",
+		Use:   "create [DIR | LABEL=DIR]...",
+		Short: "Create a file inventory for one or more directories",
+		Long:  "Recursively scan directories and create a text or JSON file listing all files found. Several directories are scanned concurrently; their relative paths are prefixed with their label, or with their base name when no label is given.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := scanConfig()
+			if err != nil {
+				return err
+			}
+			sortBudget, err := parseByteSize(sortMemory)
+			if err != nil {
+				return fmt.Errorf("invalid --sort-mem: %w", err)
+			}
+			config.SortOutput = sortOutput
+			config.Walkers = walkers
+			config.SortMemory = sortBudget
+			if err := parseReuseFlag(&config, reuse); err != nil {
+				return err
+			}
+			roots := make([]labeledRoot, len(args))
+			for i, arg := range args {
+				if roots[i], err = parseRootArg(arg); err != nil {
+					return err
+				}
+			}
+			return runCreateCommandWithRoots(roots, output, config)
+		},
+	}
+
+	var watchOpts WatchOptions
+	var watchCmd = &cobra.Command{
+		Use:   "watch [DIR]",
+		Short: "Create a file inventory and keep it up to date",
+		Long:  "Create an inventory like create does, then watch the directory for changes and rewrite the inventory as files appear, change and disappear. Runs until interrupted. Linux only.",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runCreateCommand(args[0], output, Config{
-				SortOutput:      sortOutput,
-				RelativePaths:   !fullPaths, // Default to relative paths unless --full is specified
-				IncludeHidden:   includeHidden,
-				ExcludePatterns: excludePatterns,
-				IncludePatterns: includePatterns,
-			})
+			config, err := scanConfig()
+			if err != nil {
+				return err
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return runWatchCommand(ctx, args[0], output, config, watchOpts)
 		},
 	}
 
-	createCmd.Flags().StringVarP(&output, "output", "o", "file-inventory.txt", "Output file name")
+	for _, c := range []*cobra.Command{createCmd, watchCmd} {
+		flags := c.Flags()
+		flags.StringVarP(&output, "output", "o", "file-inventory.txt", "Output file name")
+		flags.BoolVar(&fullPaths, "full", false, "Use full absolute paths (default: relative paths)")
+		flags.BoolVar(&includeHidden, "hidden", false, "Include hidden files and directories")
+		flags.StringSliceVar(&excludePatterns, "exclude", []string{}, "Exclude patterns (gitignore syntax)")
+		flags.StringSliceVar(&includePatterns, "include", []string{}, "Include patterns (gitignore syntax)")
+		flags.StringSliceVar(&attributes, "attrs", []string{}, "Per-file attributes to record (size,mtime,mode,type)")
+		flags.StringVar(&hashAlgorithm, "hash", "", "Record content digests (sha256, sha1, md5, blake2b)")
+		flags.IntVar(&jobs, "jobs", 0, "Number of parallel hashing workers (default: number of CPUs)")
+		flags.StringVar(&format, "format", formatText, "Output format (text, json, jsonl)")
+		flags.BoolVar(&respectGitignore, "respect-gitignore", false, "Also apply .gitignore files found while scanning")
+		flags.StringSliceVar(&fileTypes, "type", []string{}, "Only include these types, like find -type (f,d,l,p,s,b,c); adds the type attribute")
+		flags.BoolVar(&dirs, "dirs", false, "Also list directories, including empty ones")
+		flags.StringVar(&minSize, "min-size", "", "Only include entries at least this large (e.g. 100M)")
+		flags.StringVar(&maxSize, "max-size", "", "Only include entries at most this large (e.g. 4K)")
+		flags.StringVar(&newer, "newer", "", "Only include entries changed after this: a duration (7d), a date or a reference file")
+		flags.StringVar(&older, "older", "", "Only include entries changed before this: a duration (7d), a date or a reference file")
+		flags.StringVar(&timeField, "time", timeFieldMtime, "Timestamp compared by --newer and --older (mtime, ctime)")
+		flags.StringVar(&symlinks, "symlinks", "", "How to handle symlinks: skip, record (with their target) or follow")
+		flags.BoolVar(&noHeader, "no-header", false, "Do not describe the scan in a header; plain text inventories are then bare path lists")
+	}
 	createCmd.Flags().BoolVar(&sortOutput, "sort", false, "Sort file paths in output")
-	createCmd.Flags().BoolVar(&fullPaths, "full", false, "Use full absolute paths (default: relative paths)")
-	createCmd.Flags().BoolVar(&includeHidden, "hidden", false, "Include hidden files and directories")
-	createCmd.Flags().StringSliceVar(&excludePatterns, "exclude", []string{}, "Exclude patterns (glob)")
-	createCmd.Flags().StringSliceVar(&includePatterns, "include", []string{}, "Include patterns (glob)")
+	createCmd.Flags().IntVar(&walkers, "walkers", 1, "Number of directories to read in parallel")
+	createCmd.Flags().StringVar(&reuse, "reuse", "", "Previous inventory whose digests are kept for files with unchanged size, mtime and inode")
+	createCmd.Flags().StringVar(&sortMemory, "sort-mem", "256M", "Memory --sort may use before spilling to temporary files (e.g. 64M, 2G)")
+	watchCmd.Flags().BoolVar(&watchOpts.Changes, "changes", false, "Print a line for every entry that appears (+), disappears (-) or changes (~)")
 
 	var diffCmd = &cobra.Command{
-		Use:   "diff [FILE1] [FILE2]",
-		Short: "Show diff between two inventory files",
-		Long:  "Compare two inventory files and display differences in a formatted table.",
-		Args:  cobra.ExactArgs(2),
+		Use:   "diff [FILE1] [FILE2] [FILE...]",
+		Short: "Show diff between inventory files",
+		Long:  "Compare two inventory files and display differences in a formatted table. With three or more files, show which of them list each path that is not listed in all of them.",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateDiffFormat(diffFormat); err != nil {
+				return err
+			}
+			sortBudget, err := parseByteSize(sortMemory)
+			if err != nil {
+				return fmt.Errorf("invalid --sort-mem: %w", err)
+			}
+			err = runDiffCommandFiles(args, DiffOptions{
+				Format:          diffFormat,
+				Quiet:           quiet,
+				RenameHeuristic: renameHeuristic,
+				SortMemory:      sortBudget,
+			})
+			if errors.Is(err, errInventoriesDiffer) {
+				// Differences are reported through the exit status alone
+				cmd.SilenceErrors = true
+				cmd.SilenceUsage = true
+			}
+			return err
+		},
+	}
+
+	diffCmd.Flags().StringVar(&diffFormat, "format", diffFormatTable, "Output format (table, json, csv, markdown, tsv)")
+	diffCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Print nothing, only set the exit status")
+	diffCmd.Flags().BoolVar(&renameHeuristic, "rename-heuristic", false, "Detect renames by basename and size when inventories have no hashes")
+	diffCmd.Flags().StringVar(&sortMemory, "sort-mem", "256M", "Memory used to sort unsorted inventories before spilling to temporary files")
+
+	var verifyOpts VerifyOptions
+	var verifyCmd = &cobra.Command{
+		Use:   "verify [INVENTORY] [DIR | LABEL=DIR]...",
+		Short: "Check a directory against an inventory",
+		Long:  "Re-scan directories with the settings an inventory was created with and report missing, extra and changed files. Recorded hashes are recomputed. Without directories, those recorded in the inventory header are scanned; inventories of several directories need them with the labels they were created with.",
+		Args:  cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runDiffCommand(args[0], args[1])
+			var roots []labeledRoot
+			for _, arg := range args[1:] {
+				root, err := parseRootArg(arg)
+				if err != nil {
+					return err
+				}
+				roots = append(roots, root)
+			}
+			err := runVerifyCommandWithRoots(args[0], roots, verifyOpts)
+			if errors.Is(err, errInventoriesDiffer) {
+				// Mismatches have been listed; only the exit status is left
+				cmd.SilenceErrors = true
+				cmd.SilenceUsage = true
+			}
+			return err
 		},
 	}
 
-	rootCmd.AddCommand(createCmd, diffCmd)
+	verifyCmd.Flags().BoolVarP(&verifyOpts.Quiet, "quiet", "q", false, "Print nothing, only set the exit status")
+	verifyCmd.Flags().StringSliceVar(&verifyOpts.ExcludePatterns, "exclude", []string{}, "Exclude patterns the inventory was created with (gitignore syntax)")
+	verifyCmd.Flags().StringSliceVar(&verifyOpts.IncludePatterns, "include", []string{}, "Include patterns the inventory was created with (gitignore syntax)")
+	verifyCmd.Flags().BoolVar(&verifyOpts.RespectGitignore, "respect-gitignore", false, "Also apply .gitignore files found while scanning")
+	verifyCmd.Flags().IntVar(&verifyOpts.Jobs, "jobs", 0, "Number of parallel hashing workers (default: number of CPUs)")
 
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+	var statsOpts StatsOptions
+	var statsCmd = &cobra.Command{
+		Use:   "stats [INVENTORY | DIR]",
+		Short: "Summarize an inventory or a directory",
+		Long:  "Report file counts and total size of an inventory or directory, broken down by extension, top-level directory and depth, with a size histogram and the largest files and directories. Sizes come from the size column of inventories that record one.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runStatsCommand(args[0], statsOpts)
+		},
+	}
+
+	statsCmd.Flags().StringVar(&statsOpts.Format, "format", statsFormatTable, "Output format (table, json)")
+	statsCmd.Flags().IntVar(&statsOpts.Top, "top", defaultStatsTop, "Number of largest files and directories to list")
+	statsCmd.Flags().BoolVar(&statsOpts.IncludeHidden, "hidden", false, "Include hidden files and directories when scanning a directory")
+	statsCmd.Flags().StringSliceVar(&statsOpts.ExcludePatterns, "exclude", []string{}, "Exclude patterns when scanning a directory (gitignore syntax)")
+	statsCmd.Flags().StringSliceVar(&statsOpts.IncludePatterns, "include", []string{}, "Include patterns when scanning a directory (gitignore syntax)")
+	statsCmd.Flags().BoolVar(&statsOpts.RespectGitignore, "respect-gitignore", false, "Also apply .gitignore files found while scanning")
+
+	rootCmd.AddCommand(createCmd, diffCmd, verifyCmd, watchCmd, statsCmd)
+
+	cmd, err := rootCmd.ExecuteC()
+	if err != nil {
+		os.Exit(exitStatus(cmd, err, diffCmd, verifyCmd))
 	}
 }
 
+// Exit statuses. Commands listed in exitStatus follow the diff(1)
+// convention: 0 when inputs match, 1 when they differ, 2 on trouble.
+const (
+	exitDiffer  = 1
+	exitTrouble = 2
+)
+
+// errInventoriesDiffer is returned by comparison commands when differences
+// were found, so that main can set the exit status without printing an error
+var errInventoriesDiffer = errors.New("inventories differ")
+
+// exitStatus maps an error returned by cmd to a process exit status
+func exitStatus(cmd *cobra.Command, err error, diffStyle ...*cobra.Command) int {
+	for _, c := range diffStyle {
+		if cmd != c {
+			continue
+		}
+		if errors.Is(err, errInventoriesDiffer) {
+			return exitDiffer
+		}
+		return exitTrouble
+	}
+	return 1
+}
+
+// parsePredicateFlags validates the size and time flags of create and
+// stores them in config
+func parsePredicateFlags(config *Config, minSize, maxSize, newer, older string) error {
+	if err := validateTimeField(config.TimeField); err != nil {
+		return err
+	}
+
+	var err error
+	if minSize != "" {
+		if config.MinSize, err = parseByteSize(minSize); err != nil {
+			return fmt.Errorf("invalid --min-size: %w", err)
+		}
+	}
+	if maxSize != "" {
+		if config.MaxSize, err = parseByteSize(maxSize); err != nil {
+			return fmt.Errorf("invalid --max-size: %w", err)
+		}
+		config.HasMaxSize = true
+	}
+
+	now := time.Now()
+	if newer != "" {
+		if config.NewerThan, err = parseTimeSpec(newer, config.TimeField, now); err != nil {
+			return fmt.Errorf("invalid --newer: %w", err)
+		}
+	}
+	if older != "" {
+		if config.OlderThan, err = parseTimeSpec(older, config.TimeField, now); err != nil {
+			return fmt.Errorf("invalid --older: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseReuseFlag loads the previous inventory given to --reuse into config.
+// The attributes needed to reuse the new inventory in turn are added. A
+// missing previous inventory, as on a first run, means hashing every file.
+func parseReuseFlag(config *Config, previous string) error {
+	if previous == "" {
+		return nil
+	}
+	if config.HashAlgorithm == "" {
+		return fmt.Errorf("--reuse requires --hash")
+	}
+
+	attrs, err := parseAttributes(append([]string{AttrSize, AttrMtime, AttrInode}, config.Attributes...))
+	if err != nil {
+		return err
+	}
+	config.Attributes = attrs
+
+	cache, err := loadDigestCache(previous, config.HashAlgorithm)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Warning: previous inventory %q not found, hashing every file\n", previous)
+		cache = newDigestCache()
+	} else if err != nil {
+		return fmt.Errorf("invalid --reuse: %w", err)
+	}
+	config.Reuse = cache
+	return nil
+}
+
 func runCreateCommand(dirPath, output string, config Config) error {
-	files, err := findFilesWithConfig(dirPath, config)
+	return runCreateCommandWithRoots([]labeledRoot{{Path: dirPath}}, output, config)
+}
+
+// runCreateCommandWithRoots writes one inventory of all roots
+func runCreateCommandWithRoots(roots []labeledRoot, output string, config Config) error {
+	// Check the directories before creating the output file
+	roots, err := resolveRoots(roots)
+	if err != nil {
+		return err
+	}
+
+	// The inventory is written next to output and renamed into place once
+	// the scan succeeds, so a failed scan leaves any previous one intact.
+	// Neither file is listed when output is inside a scanned directory.
+	absOutput, err := filepath.Abs(output)
 	if err != nil {
-		return fmt.Errorf("failed to scan directory %q: %w", dirPath, err)
+		return fmt.Errorf("failed to resolve %q: %w", output, err)
 	}
+	config.Skip = append(slices.Clip(config.Skip), absOutput, tempNameFor(absOutput))
 
-	if err := writeFileList(output, files); err != nil {
+	var header *inventoryHeader
+	if config.Header {
+		header = newInventoryHeader(roots, config)
+	}
+	temp := tempNameFor(output)
+	inv, err := createInventoryWithHeader(temp, config.Format, config.Columns(), header)
+	if err != nil {
 		return fmt.Errorf("failed to write inventory to %q: %w", output, err)
 	}
+	written := false
+	defer func() {
+		inv.Close()
+		if !written {
+			os.Remove(temp)
+		}
+	}()
+
+	if config.SortOutput {
+		// Sorting needs every entry before the first one can be written, so
+		// entries go through a sorter that spills to disk beyond its budget
+		sorter := newEntrySorter(config.Columns(), config.SortMemory)
+		defer sorter.Close()
+		err = scanDirectories(roots, config, sorter.Add)
+		if err == nil {
+			err = sorter.Each(inv.Write)
+		}
+	} else {
+		// Stream entries straight to the output file
+		err = scanDirectories(roots, config, inv.Write)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := inv.Close(); err != nil {
+		return fmt.Errorf("failed to write inventory to %q: %w", output, err)
+	}
+	if err := os.Rename(temp, output); err != nil {
+		return fmt.Errorf("failed to write inventory to %q: %w", output, err)
+	}
+	written = true
 
 	fmt.Printf("Inventory written to %s\n", output)
-	fmt.Printf("Total files found: %d\n", len(files))
+	fmt.Printf("Total files found: %d\n", inv.Count())
+	if config.Reuse != nil {
+		fmt.Printf("Hashes reused: %d, recomputed: %d\n", config.Reuse.Reused(), config.Reuse.Recomputed())
+	}
 	return nil
 }
 
-func runDiffCommand(file1, file2 string) error {
-	if err := showDiff(file1, file2); err != nil {
+// runDiffCommand compares two inventories and returns errInventoriesDiffer
+// when they differ
+func runDiffCommand(file1, file2 string, opts DiffOptions) error {
+	return runDiffCommandFiles([]string{file1, file2}, opts)
+}
+
+// runDiffCommandFiles compares two or more inventories and returns
+// errInventoriesDiffer when they differ
+func runDiffCommandFiles(files []string, opts DiffOptions) error {
+	differ, err := showDiffFiles(files, opts)
+	if err != nil {
 		return fmt.Errorf("failed to compare files: %w", err)
 	}
+	if differ {
+		return errInventoriesDiffer
+	}
 	return nil
 }
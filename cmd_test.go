@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -29,6 +32,12 @@ func TestRunCreateCommand(t *testing.T) {
 			config:      Config{SortOutput: true},
 			expectCount: 3,
 		},
+		{
+			name:        "with sorting spilled to disk",
+			setupFiles:  []string{"z.txt", "sub/b.txt", "a.txt", "m.txt", "sub/a.txt"},
+			config:      Config{SortOutput: true, SortMemory: 1},
+			expectCount: 5,
+		},
 		{
 			name:        "with full paths",
 			setupFiles:  []string{"file1.txt", "sub/file2.txt"},
@@ -101,15 +110,22 @@ func TestRunCreateCommand(t *testing.T) {
 
 func TestRunDiffCommand(t *testing.T) {
 	tests := []struct {
-		name        string
-		content1    string
-		content2    string
-		expectError bool
+		name         string
+		content1     string
+		content2     string
+		expectError  bool
+		expectDiffer bool
 	}{
 		{
-			name:     "valid files",
+			name:         "valid files",
+			content1:     "file1.txt\nfile2.txt\n",
+			content2:     "file1.txt\nfile3.txt\n",
+			expectDiffer: true,
+		},
+		{
+			name:     "identical files",
 			content1: "file1.txt\nfile2.txt\n",
-			content2: "file1.txt\nfile3.txt\n",
+			content2: "file2.txt\nfile1.txt\n",
 		},
 		{
 			name:        "nonexistent file1",
@@ -136,12 +152,15 @@ func TestRunDiffCommand(t *testing.T) {
 				}
 			}
 
-			err := runDiffCommand(file1, file2)
+			err := runDiffCommand(file1, file2, DiffOptions{Quiet: true})
 
-			if tt.expectError && err == nil {
-				t.Error("Expected error but got none")
+			if tt.expectError && (err == nil || errors.Is(err, errInventoriesDiffer)) {
+				t.Errorf("Expected error but got %v", err)
 			}
-			if !tt.expectError && err != nil {
+			if tt.expectDiffer && !errors.Is(err, errInventoriesDiffer) {
+				t.Errorf("Expected errInventoriesDiffer, got %v", err)
+			}
+			if !tt.expectError && !tt.expectDiffer && err != nil {
 				t.Fatalf("Unexpected error: %v", err)
 			}
 		})
@@ -219,12 +238,215 @@ func TestCobraDiffCommand(t *testing.T) {
 		Short: "Show diff between two inventory files",
 		Args:  cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runDiffCommand(args[0], args[1])
+			return runDiffCommand(args[0], args[1], DiffOptions{})
 		},
 	}
 
 	diffCmd.SetArgs([]string{fileA, fileB})
-	if err := diffCmd.Execute(); err != nil {
-		t.Fatalf("Cobra diff command failed: %v", err)
+	if err := diffCmd.Execute(); !errors.Is(err, errInventoriesDiffer) {
+		t.Fatalf("Expected cobra diff command to report differences, got: %v", err)
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	diffCmd := &cobra.Command{Use: "diff"}
+	createCmd := &cobra.Command{Use: "create"}
+	verifyCmd := &cobra.Command{Use: "verify"}
+
+	tests := []struct {
+		name     string
+		cmd      *cobra.Command
+		err      error
+		expected int
+	}{
+		{"diff with differences", diffCmd, errInventoriesDiffer, exitDiffer},
+		{"diff with wrapped differences", diffCmd, fmt.Errorf("wrapped: %w", errInventoriesDiffer), exitDiffer},
+		{"diff failure", diffCmd, errors.New("cannot read"), exitTrouble},
+		{"verify with mismatches", verifyCmd, errInventoriesDiffer, exitDiffer},
+		{"verify failure", verifyCmd, errors.New("cannot scan"), exitTrouble},
+		{"create failure", createCmd, errors.New("cannot scan"), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := exitStatus(tt.cmd, tt.err, diffCmd, verifyCmd); status != tt.expected {
+				t.Errorf("Expected exit status %d, got %d", tt.expected, status)
+			}
+		})
+	}
+}
+
+func TestParsePredicateFlags(t *testing.T) {
+	var config Config
+	if err := parsePredicateFlags(&config, "1K", "2M", "7d", "2024-01-01"); err != nil {
+		t.Fatalf("parsePredicateFlags failed: %v", err)
+	}
+	if config.MinSize != 1024 || config.MaxSize != 2<<20 || config.NewerThan.IsZero() || config.OlderThan.IsZero() {
+		t.Errorf("Unexpected config: %+v", config)
+	}
+
+	invalid := [][4]string{
+		{"big", "", "", ""},
+		{"", "", "someday", ""},
+		{"", "", "", "-1h"},
+	}
+	for _, flags := range invalid {
+		if err := parsePredicateFlags(&Config{}, flags[0], flags[1], flags[2], flags[3]); err == nil {
+			t.Errorf("Expected error for %q", flags)
+		}
+	}
+	// --max-size 0 selects empty entries rather than lifting the limit
+	config = Config{}
+	if err := parsePredicateFlags(&config, "", "0", "", ""); err != nil || !config.HasMaxSize || config.MaxSize != 0 {
+		t.Errorf("Expected --max-size 0 to be accepted, got %+v, %v", config, err)
+	}
+
+	if err := parsePredicateFlags(&Config{TimeField: "atime"}, "", "", "", ""); err == nil {
+		t.Error("Expected error for unsupported time field")
+	}
+}
+
+func TestCreateWithReuse(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "same.txt"), []byte("same"), 0644)
+	os.WriteFile(filepath.Join(dir, "edited.txt"), []byte("old"), 0644)
+	output := filepath.Join(t.TempDir(), "inventory.txt")
+
+	create := func() *Config {
+		t.Helper()
+		config := Config{RelativePaths: true, SortOutput: true, HashAlgorithm: "sha256"}
+		if err := parseReuseFlag(&config, output); err != nil {
+			t.Fatalf("parseReuseFlag failed: %v", err)
+		}
+		if err := runCreateCommand(dir, output, config); err != nil {
+			t.Fatalf("runCreateCommand failed: %v", err)
+		}
+		return &config
+	}
+
+	// The first run has nothing to reuse and records what the next one needs
+	config := create()
+	if config.Reuse.Reused() != 0 || config.Reuse.Recomputed() != 2 {
+		t.Errorf("First run: expected 0 reused and 2 recomputed, got %d and %d", config.Reuse.Reused(), config.Reuse.Recomputed())
+	}
+	if strings.Join(config.Columns(), ",") != "size,mtime,inode,hash" {
+		t.Errorf("Unexpected columns %v", config.Columns())
+	}
+	before := readHashes(t, output)
+
+	// Rewriting a file with the same size and mtime keeps its old digest,
+	// which shows it was not read again
+	info, _ := os.Stat(filepath.Join(dir, "same.txt"))
+	os.WriteFile(filepath.Join(dir, "same.txt"), []byte("SAME"), 0644)
+	os.Chtimes(filepath.Join(dir, "same.txt"), info.ModTime(), info.ModTime())
+	os.WriteFile(filepath.Join(dir, "edited.txt"), []byte("new content"), 0644)
+
+	config = create()
+	if config.Reuse.Reused() != 1 || config.Reuse.Recomputed() != 1 {
+		t.Errorf("Second run: expected 1 reused and 1 recomputed, got %d and %d", config.Reuse.Reused(), config.Reuse.Recomputed())
+	}
+	after := readHashes(t, output)
+	if after["same.txt"] != before["same.txt"] {
+		t.Errorf("Expected the digest of same.txt to be reused")
+	}
+	if after["edited.txt"] == before["edited.txt"] {
+		t.Errorf("Expected the digest of edited.txt to be recomputed")
+	}
+
+	if err := parseReuseFlag(&Config{}, output); err == nil {
+		t.Error("Expected error for --reuse without --hash")
+	}
+}
+
+// readHashes returns the digests recorded in an inventory by path
+func readHashes(t *testing.T, filename string) map[string]string {
+	t.Helper()
+	inv, err := readInventory(filename)
+	if err != nil {
+		t.Fatalf("readInventory failed: %v", err)
+	}
+	hashes := make(map[string]string)
+	for _, entry := range inv.Entries {
+		hashes[entry.Path] = entry.Hash
+	}
+	return hashes
+}
+
+func TestRunCreateCommandWithRoots(t *testing.T) {
+	base := t.TempDir()
+	os.MkdirAll(filepath.Join(base, "etc"), 0755)
+	os.MkdirAll(filepath.Join(base, "opt", "app"), 0755)
+	os.WriteFile(filepath.Join(base, "etc", "hosts"), []byte("127.0.0.1"), 0644)
+	os.WriteFile(filepath.Join(base, "opt", "app", "server"), []byte("bin"), 0644)
+	output := filepath.Join(t.TempDir(), "inventory.txt")
+
+	var roots []labeledRoot
+	for _, arg := range []string{filepath.Join(base, "etc"), "app=" + filepath.Join(base, "opt", "app")} {
+		root, err := parseRootArg(arg)
+		if err != nil {
+			t.Fatalf("parseRootArg failed: %v", err)
+		}
+		roots = append(roots, root)
+	}
+
+	config := Config{RelativePaths: true, SortOutput: true, Header: true}
+	if err := runCreateCommandWithRoots(roots, output, config); err != nil {
+		t.Fatalf("runCreateCommandWithRoots failed: %v", err)
+	}
+
+	inv, err := readInventory(output)
+	if err != nil {
+		t.Fatalf("readInventory failed: %v", err)
+	}
+	var paths []string
+	for _, entry := range inv.Entries {
+		paths = append(paths, filepath.ToSlash(entry.Path))
+	}
+	if strings.Join(paths, ",") != "app/server,etc/hosts" {
+		t.Errorf("Unexpected paths %v", paths)
+	}
+	if inv.Header == nil || len(inv.Header.Roots) != 2 || inv.Header.Roots[1].Label != "app" {
+		t.Errorf("Expected both roots in the header, got %+v", inv.Header)
+	}
+}
+
+func TestRunCreateCommandOutputInTree(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0644)
+	output := filepath.Join(dir, "file-inventory.txt")
+	config := Config{RelativePaths: true, HashAlgorithm: "sha256", SortOutput: true, Header: true}
+
+	// The inventory never lists itself, even when it already exists
+	for i := 0; i < 2; i++ {
+		if err := runCreateCommand(dir, output, config); err != nil {
+			t.Fatalf("runCreateCommand failed: %v", err)
+		}
+	}
+	lines, err := readFileLines(output)
+	if err != nil {
+		t.Fatalf("readFileLines failed: %v", err)
+	}
+	if _, ok := lines["a.txt"]; len(lines) != 1 || !ok {
+		t.Errorf("Expected only a.txt, got %v", lines)
+	}
+	if err := runVerifyCommandWithRoots(output, []labeledRoot{{Path: dir}}, VerifyOptions{Quiet: true}); err != nil {
+		t.Errorf("Expected the inventory to verify, got %v", err)
+	}
+
+	// A failed scan leaves the previous inventory in place
+	previous, _ := os.ReadFile(output)
+	missing := filepath.Join(t.TempDir(), "missing")
+	t.Setenv("TMPDIR", missing)
+	t.Setenv("TMP", missing)
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("world"), 0644)
+	config.SortMemory = 1
+	if err := runCreateCommand(dir, output, config); err == nil {
+		t.Fatal("Expected error when sorted entries cannot spill to disk")
+	}
+	if current, _ := os.ReadFile(output); !bytes.Equal(current, previous) {
+		t.Errorf("Expected the previous inventory to be kept, got:\n%s", current)
+	}
+	if _, err := os.Stat(tempNameFor(output)); !os.IsNotExist(err) {
+		t.Errorf("Expected the temporary file to be removed, got %v", err)
 	}
 }
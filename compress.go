@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported compressions of inventory files. Output is compressed according
+// to the file extension; input is recognized by its magic bytes, so the name
+// of a compressed inventory does not matter when reading it.
+const (
+	compressionNone = ""
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressionExtensions maps output file extensions to compressions
+var compressionExtensions = map[string]string{
+	".gz":  compressionGzip,
+	".zst": compressionZstd,
+}
+
+// compressionOf returns the compression implied by the extension of filename
+func compressionOf(filename string) string {
+	return compressionExtensions[strings.ToLower(filepath.Ext(filename))]
+}
+
+// tempNameFor returns a name next to filename for writing a replacement,
+// keeping the compression extension so the copy is compressed the same way
+func tempNameFor(filename string) string {
+	if compressionOf(filename) == compressionNone {
+		return filename + ".tmp"
+	}
+	ext := filepath.Ext(filename)
+	return strings.TrimSuffix(filename, ext) + ".tmp" + ext
+}
+
+// newCompressedWriter wraps w so that what is written to it is compressed.
+// Closing it flushes the compressed stream but does not close w.
+func newCompressedWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case compressionGzip:
+		return gzip.NewWriter(w), nil
+	case compressionZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// detectCompression peeks at the start of r for the magic bytes of a
+// supported compression without consuming any input
+func detectCompression(r *bufio.Reader) string {
+	head, _ := r.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return compressionGzip
+	case bytes.HasPrefix(head, zstdMagic):
+		return compressionZstd
+	default:
+		return compressionNone
+	}
+}
+
+// inventoryFile is an inventory opened for reading, decompressed if needed
+type inventoryFile struct {
+	*bufio.Reader
+
+	f     *os.File
+	close func() // releases the decompressor, if any
+}
+
+// openInventoryFile opens filename and returns a buffered reader over its
+// decompressed contents
+func openInventoryFile(filename string) (*inventoryFile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := bufio.NewReaderSize(f, 64*1024)
+	file := &inventoryFile{Reader: raw, f: f, close: func() {}}
+	switch detectCompression(raw) {
+	case compressionGzip:
+		zr, err := gzip.NewReader(raw)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("invalid gzip data: %w", err)
+		}
+		file.Reader = bufio.NewReaderSize(zr, 64*1024)
+		file.close = func() { zr.Close() }
+	case compressionZstd:
+		zr, err := zstd.NewReader(raw, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("invalid zstd data: %w", err)
+		}
+		file.Reader = bufio.NewReaderSize(zr, 64*1024)
+		file.close = zr.Close
+	}
+	return file, nil
+}
+
+// Close releases the decompressor and closes the file
+func (file *inventoryFile) Close() error {
+	file.close()
+	return file.f.Close()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompressionOf(t *testing.T) {
+	tests := []struct {
+		filename string
+		expected string
+	}{
+		{"inventory.txt", compressionNone},
+		{"inventory.txt.gz", compressionGzip},
+		{"inventory.jsonl.GZ", compressionGzip},
+		{"inventory.zst", compressionZstd},
+		{"inventory.gz.txt", compressionNone},
+	}
+	for _, tt := range tests {
+		if got := compressionOf(tt.filename); got != tt.expected {
+			t.Errorf("compressionOf(%q): expected %q, got %q", tt.filename, tt.expected, got)
+		}
+	}
+}
+
+func TestTempNameFor(t *testing.T) {
+	tests := map[string]string{
+		"inventory.txt":     "inventory.txt.tmp",
+		"inventory.txt.gz":  "inventory.txt.tmp.gz",
+		"inventory.txt.zst": "inventory.txt.tmp.zst",
+	}
+	for filename, expected := range tests {
+		if got := tempNameFor(filename); got != expected {
+			t.Errorf("tempNameFor(%q): expected %q, got %q", filename, expected, got)
+		}
+	}
+}
+
+func TestCompressedInventoryRoundTrip(t *testing.T) {
+	columns := []string{AttrSize, AttrMtime}
+	var entries []FileEntry
+	for i := 0; i < 1000; i++ {
+		entries = append(entries, FileEntry{
+			Path:    filepath.Join("very/repetitive/directory/names", string(rune('a'+i%26)), "file.txt"),
+			Size:    int64(i),
+			ModTime: time.Date(2024, 5, 1, 12, 0, i%60, 0, time.UTC),
+		})
+	}
+
+	for _, ext := range []string{".gz", ".zst"} {
+		for _, format := range []string{formatText, formatJSON, formatJSONL} {
+			t.Run(format+ext, func(t *testing.T) {
+				dir := t.TempDir()
+				plain := filepath.Join(dir, "inventory."+format)
+				compressed := plain + ext
+				if err := writeInventory(plain, format, columns, entries); err != nil {
+					t.Fatalf("writeInventory failed: %v", err)
+				}
+				if err := writeInventory(compressed, format, columns, entries); err != nil {
+					t.Fatalf("writeInventory failed: %v", err)
+				}
+
+				plainInfo, _ := os.Stat(plain)
+				compressedInfo, _ := os.Stat(compressed)
+				if compressedInfo.Size() >= plainInfo.Size() {
+					t.Errorf("Expected %s (%d bytes) to be smaller than %s (%d bytes)",
+						compressed, compressedInfo.Size(), plain, plainInfo.Size())
+				}
+
+				// Compression is detected from the contents, not the name
+				renamed := filepath.Join(dir, "renamed")
+				if err := os.Rename(compressed, renamed); err != nil {
+					t.Fatalf("Rename failed: %v", err)
+				}
+				inv, err := readInventory(renamed)
+				if err != nil {
+					t.Fatalf("readInventory failed: %v", err)
+				}
+				if !reflect.DeepEqual(inv.Columns, columns) || !reflect.DeepEqual(inv.Entries, entries) {
+					t.Errorf("Round trip changed the inventory: columns %v, %d entries", inv.Columns, len(inv.Entries))
+				}
+
+				differ, err := showDiffFiles([]string{plain, renamed}, DiffOptions{Quiet: true})
+				if err != nil {
+					t.Fatalf("showDiffFiles failed: %v", err)
+				}
+				if differ {
+					t.Error("Expected compressed and plain inventories to match")
+				}
+			})
+		}
+	}
+}
+
+func TestRunCreateCommandCompressed(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("world"), 0644)
+
+	outDir := t.TempDir()
+	gz := filepath.Join(outDir, "inventory.txt.gz")
+	zst := filepath.Join(outDir, "inventory.txt.zst")
+	if err := runCreateCommand(dir, gz, Config{RelativePaths: true, SortOutput: true}); err != nil {
+		t.Fatalf("runCreateCommand failed: %v", err)
+	}
+	if err := runCreateCommand(dir, zst, Config{RelativePaths: true, SortOutput: true}); err != nil {
+		t.Fatalf("runCreateCommand failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(gz)
+	if !bytes.HasPrefix(data, gzipMagic) {
+		t.Errorf("Expected gzip output, got % x", data[:min(len(data), 4)])
+	}
+	data, _ = os.ReadFile(zst)
+	if !bytes.HasPrefix(data, zstdMagic) {
+		t.Errorf("Expected zstd output, got % x", data[:min(len(data), 4)])
+	}
+
+	lines, err := readFileLines(gz)
+	if err != nil {
+		t.Fatalf("readFileLines failed: %v", err)
+	}
+	if len(lines) != 2 {
+		t.Errorf("Expected 2 entries, got %v", lines)
+	}
+	differ, err := showDiffFiles([]string{gz, zst}, DiffOptions{Quiet: true})
+	if err != nil || differ {
+		t.Errorf("Expected gzip and zstd inventories to match, got %v, %v", differ, err)
+	}
+}
+
+func TestReadInventoryCorruptCompression(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "inventory.txt.gz")
+	if err := writeInventory(file, formatText, nil, []FileEntry{{Path: "a.txt"}}); err != nil {
+		t.Fatalf("writeInventory failed: %v", err)
+	}
+	data, _ := os.ReadFile(file)
+	os.WriteFile(file, data[:len(data)-6], 0644)
+
+	if _, err := readInventory(file); err == nil {
+		t.Error("Expected error for truncated gzip inventory")
+	}
+}
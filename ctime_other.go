@@ -0,0 +1,15 @@
+//go:build !(linux || openbsd || dragonfly || solaris || darwin || freebsd || netbsd)
+
+package main
+
+import (
+	"io/fs"
+	"time"
+)
+
+// ctimeSupported is false where the inode change time is not exposed
+const ctimeSupported = false
+
+func changeTime(info fs.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
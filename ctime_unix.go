@@ -0,0 +1,20 @@
+//go:build linux || openbsd || dragonfly || solaris
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+const ctimeSupported = true
+
+// changeTime returns the inode change time recorded in info
+func changeTime(info fs.FileInfo) (time.Time, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(st.Ctim.Unix()), true
+}
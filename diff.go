@@ -1,106 +1,749 @@
 package main
 
 import (
-	"bufio"
+	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
 
-	"github.com/olekukonko/tablewriter"
-	"github.com/olekukonko/tablewriter/tw"
+// Diff statuses for a path
+const (
+	statusAdded    = "added"    // only in the second inventory
+	statusRemoved  = "removed"  // only in the first inventory
+	statusModified = "modified" // in both, with differing attributes
+	statusRenamed  = "renamed"  // moved from OldPath to Path
+	statusPartial  = "partial"  // in some of three or more inventories
 )
 
+// diffRow is a single path that differs between two inventories
+type diffRow struct {
+	Path    string
+	OldPath string // previous path of renamed entries
+	Status  string
+	Changes []string // human-readable attribute changes for modified paths
+	Present []bool   // for partial paths, whether each inventory lists it
+
+	oldEntry FileEntry // entry in the first inventory, if any
+	newEntry FileEntry // entry in the second inventory, if any
+}
+
+// displayPath returns the path as shown in human-readable output
+func (r diffRow) displayPath() string {
+	if r.Status == statusRenamed {
+		return r.OldPath + " → " + r.Path
+	}
+	return r.Path
+}
+
+// isDir reports whether the row is about a directory listed with create --dirs
+func (r diffRow) isDir() bool {
+	return strings.HasSuffix(r.Path, dirSuffix) || r.oldEntry.IsDir() || r.newEntry.IsDir()
+}
+
+// DiffOptions controls how differences are reported
+type DiffOptions struct {
+	Format string    // table (default), json, csv, markdown or tsv
+	Output io.Writer // defaults to os.Stdout
+	Quiet  bool      // only compute the result, print nothing
+
+	// RenameHeuristic pairs removed and added entries by basename and size
+	// when content hashes are not available
+	RenameHeuristic bool
+
+	// SortMemory is the memory budget for sorting inventories that are not
+	// sorted by path, 0 for the default
+	SortMemory int64
+}
+
+// diffResult is the outcome of comparing two inventories, or of building
+// the presence matrix of three or more
+type diffResult struct {
+	Files   []string           // inventory file names, in argument order
+	Headers []*inventoryHeader // headers of the inventories, nil where absent
+	Columns []string           // attribute columns that were compared
+	Rows    []diffRow
+}
+
 // showDiff compares two files and prints lines unique to each in table format
 func showDiff(file1, file2 string) error {
-	set1, err := readFileLines(file1)
+	_, err := showDiffFiles([]string{file1, file2}, DiffOptions{})
+	return err
+}
+
+// showDiffFiles compares two or more files and prints the differences in
+// the requested format. Two files are compared attribute by attribute;
+// three or more give a presence matrix of the paths not listed in all of
+// them. It reports whether the inventories differ.
+//
+// A quiet diff stops at the first difference, and CSV and TSV rows are
+// written as the merge-join finds them unless renames have to be paired.
+// Both need inputs that are known to be sorted, so inventories without a
+// sorted header are sorted on disk first rather than when found unsorted.
+func showDiffFiles(files []string, opts DiffOptions) (bool, error) {
+	output := opts.Output
+	if output == nil {
+		output = os.Stdout
+	}
+
+	differ := false
+	var sink rowSink
+	var delimited *csv.Writer
+	switch {
+	case opts.Quiet:
+		sink = func(*diffResult) (func(diffRow) error, error) {
+			return func(diffRow) error {
+				differ = true
+				return errStopDiff
+			}, nil
+		}
+	case opts.Format == diffFormatCSV || opts.Format == diffFormatTSV:
+		sink = func(result *diffResult) (func(diffRow) error, error) {
+			if len(result.Files) == 2 && (containsString(result.Columns, AttrHash) || opts.RenameHeuristic) {
+				// Renames pair rows that are found far apart
+				return nil, nil
+			}
+			warnIncompatible(result.Files, result.Headers)
+			delimited = newDelimitedWriter(output, opts.Format)
+			if err := writeDelimitedHeader(delimited, *result); err != nil {
+				return nil, err
+			}
+			return func(row diffRow) error {
+				differ = true
+				return writeDelimitedRow(delimited, row)
+			}, nil
+		}
+	}
+
+	var result diffResult
+	var err error
+	if len(files) == 2 {
+		if result, err = diffInventories(files[0], files[1], opts.SortMemory, sink); err == nil {
+			result.Rows = detectRenames(result.Rows, result.Columns, opts.RenameHeuristic)
+		}
+	} else {
+		result, err = presenceMatrix(files, opts.SortMemory, sink)
+	}
+	if err != nil {
+		return false, err
+	}
+	if opts.Quiet {
+		return differ, nil
+	}
+	if delimited != nil {
+		delimited.Flush()
+		return differ, delimited.Error()
+	}
+
+	warnIncompatible(result.Files, result.Headers)
+	return len(result.Rows) > 0, renderDiff(output, result, opts.Format)
+}
+
+// errStopDiff ends a merge-join early, once a quiet diff found a difference
+var errStopDiff = errors.New("difference found")
+
+// rowSink takes the rows of a merge-join as they are found instead of
+// collecting them in the result. It is set up once the inputs are open and
+// the headers and compared columns known, and returns the function each row
+// is passed to, which may return errStopDiff to end the comparison. A nil
+// function collects the rows after all.
+type rowSink func(result *diffResult) (func(diffRow) error, error)
+
+// diffInventories compares two inventory files with a merge-join, reading
+// each once with constant memory when both are sorted by path. Only the
+// differing rows are kept, or passed to sink when it is set. Without a sink,
+// an input found to be unsorted is passed through the external sorter and
+// the comparison restarted.
+func diffInventories(file1, file2 string, sortMemory int64, sink rowSink) (diffResult, error) {
+	var sortInput [2]bool
+	for {
+		result, unsorted, err := diffPass([2]string{file1, file2}, sortInput, sortMemory, sink)
+		if unsorted < 0 || err == nil {
+			return result, err
+		}
+		sortInput[unsorted] = true
+	}
+}
+
+// diffPass runs one merge-join over the two files, sorting those flagged in
+// sortInput first. If an input turns out not to be sorted, the pass is
+// abandoned and its index returned; otherwise the index is -1.
+func diffPass(files [2]string, sortInput [2]bool, sortMemory int64, sink rowSink) (diffResult, int, error) {
+	inputs, err := openDiffInputs(files[:], sortInput[:], sink != nil, sortMemory)
 	if err != nil {
-		return fmt.Errorf("error reading %s: %w", file1, err)
+		return diffResult{}, -1, err
 	}
+	defer closeDiffInputs(inputs)
 
-	set2, err := readFileLines(file2)
+	result := diffResult{
+		Files:   files[:],
+		Headers: []*inventoryHeader{inputs[0].header, inputs[1].header},
+		Columns: comparableColumns(&Inventory{Columns: inputs[0].columns}, &Inventory{Columns: inputs[1].columns}),
+	}
+	emit, streaming, err := startRows(&result, sink)
 	if err != nil {
-		return fmt.Errorf("error reading %s: %w", file2, err)
+		return diffResult{}, -1, err
 	}
+	err = mergeDiff(inputs[0].src, inputs[1].src, result.Columns, emit)
+	if unsorted, err := finishPass(files[:], inputs, streaming, err); err != nil {
+		return diffResult{}, unsorted, err
+	}
+	return result, -1, nil
+}
 
-	// Collect all unique files
-	allFiles := make(map[string]struct{})
-	for line := range set1 {
-		if _, ok := set2[line]; !ok {
-			allFiles[line] = struct{}{}
+// presenceMatrix lists the paths that some but not all of files list, with
+// a merge-join over all of them. Like diffInventories, it sorts inputs
+// found to be unsorted and starts over, or passes rows to sink.
+func presenceMatrix(files []string, sortMemory int64, sink rowSink) (diffResult, error) {
+	sortInput := make([]bool, len(files))
+	for {
+		result, unsorted, err := presencePass(files, sortInput, sortMemory, sink)
+		if unsorted < 0 || err == nil {
+			return result, err
 		}
+		sortInput[unsorted] = true
 	}
-	for line := range set2 {
-		if _, ok := set1[line]; !ok {
-			allFiles[line] = struct{}{}
+}
+
+// presencePass runs one merge-join over files, as diffPass does for two
+func presencePass(files []string, sortInput []bool, sortMemory int64, sink rowSink) (diffResult, int, error) {
+	inputs, err := openDiffInputs(files, sortInput, sink != nil, sortMemory)
+	if err != nil {
+		return diffResult{}, -1, err
+	}
+	defer closeDiffInputs(inputs)
+
+	result := diffResult{Files: files, Headers: make([]*inventoryHeader, len(files))}
+	srcs := make([]entrySource, len(inputs))
+	for i, input := range inputs {
+		result.Headers[i] = input.header
+		srcs[i] = input.src
+	}
+	emit, streaming, err := startRows(&result, sink)
+	if err != nil {
+		return diffResult{}, -1, err
+	}
+	err = mergePresence(srcs, emit)
+	if unsorted, err := finishPass(files, inputs, streaming, err); err != nil {
+		return diffResult{}, unsorted, err
+	}
+	return result, -1, nil
+}
+
+// startRows returns the function a pass passes its rows to: the one sink
+// sets up, in which case streaming is true, or one that collects them in
+// result
+func startRows(result *diffResult, sink rowSink) (emit func(diffRow) error, streaming bool, err error) {
+	if sink != nil {
+		if emit, err = sink(result); emit != nil || err != nil {
+			return emit, true, err
 		}
 	}
+	return func(row diffRow) error {
+		result.Rows = append(result.Rows, row)
+		return nil
+	}, false, nil
+}
 
-	// Sort files for consistent output
-	var sortedFiles []string
-	for filePath := range allFiles {
-		sortedFiles = append(sortedFiles, filePath)
+// finishPass works out how a merge-join that ended with err went, like
+// checkDiffInputs. Streamed rows cannot be taken back, so an input that
+// turns out unsorted despite its header is then an error rather than a
+// reason to start over.
+func finishPass(files []string, inputs []*diffInput, streaming bool, err error) (int, error) {
+	if errors.Is(err, errStopDiff) {
+		return -1, nil
+	}
+	unsorted, err := checkDiffInputs(files, inputs, err)
+	if unsorted >= 0 && streaming {
+		return -1, fmt.Errorf("error reading %s: %w", files[unsorted], err)
 	}
-	sort.Strings(sortedFiles)
+	return unsorted, err
+}
 
-	// Create table
-	table := tablewriter.NewWriter(os.Stdout)
-	table.Options(
-		tablewriter.WithHeaderAutoFormat(tw.Off),
-		tablewriter.WithRendition(tw.Rendition{
-			Borders: tw.Border{
-				Left:   tw.Off,
-				Right:  tw.Off,
-				Top:    tw.Off,
-				Bottom: tw.Off,
-			},
-		}),
-	)
-	table.Header("file_path", file1, file2)
+// diffInput is an inventory opened for a merge-join
+type diffInput struct {
+	header  *inventoryHeader
+	columns []string
+	src     *orderedSource
+	closers []func() error
+}
 
-	// Add differences to table
-	for _, filePath := range sortedFiles {
-		_, inFile1 := set1[filePath]
-		_, inFile2 := set2[filePath]
+// openDiffInputs opens files for a merge-join, passing those flagged in
+// sortInput through the external sorter first. With presort, so are those
+// without a header saying they are sorted.
+func openDiffInputs(files []string, sortInput []bool, presort bool, sortMemory int64) ([]*diffInput, error) {
+	var inputs []*diffInput
+	for i, file := range files {
+		input, err := openDiffInput(file, sortInput[i], presort, sortMemory)
+		if err != nil {
+			closeDiffInputs(inputs)
+			return nil, err
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs, nil
+}
 
-		file1Status := " "
-		file2Status := " "
+func openDiffInput(file string, sortInput, presort bool, sortMemory int64) (*diffInput, error) {
+	reader, err := openInventory(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", file, err)
+	}
+	input := &diffInput{header: reader.Header, columns: reader.Columns, closers: []func() error{reader.Close}}
 
-		if inFile1 && !inFile2 {
-			file1Status = "+"
-			file2Status = "-"
-		} else if !inFile1 && inFile2 {
-			file1Status = "-"
-			file2Status = "+"
+	var src entrySource = reader
+	if sortInput || (presort && (reader.Header == nil || !reader.Header.Config.Sorted)) {
+		sorter := newEntrySorter(reader.Columns, sortMemory)
+		input.closers = append(input.closers, sorter.Close)
+		if err := copyEntries(sorter, reader); err != nil {
+			input.close()
+			return nil, fmt.Errorf("error reading %s: %w", file, err)
 		}
+		if src, err = sorter.Sorted(); err != nil {
+			input.close()
+			return nil, fmt.Errorf("error sorting %s: %w", file, err)
+		}
+	}
+	input.src = &orderedSource{src: src}
+	return input, nil
+}
 
-		table.Append(filePath, file1Status, file2Status)
+func (in *diffInput) close() {
+	for i := len(in.closers) - 1; i >= 0; i-- {
+		in.closers[i]()
 	}
+}
 
-	table.Render()
-	return nil
+func closeDiffInputs(inputs []*diffInput) {
+	for _, input := range inputs {
+		input.close()
+	}
+}
+
+// checkDiffInputs works out what a merge-join over inputs that ended with
+// err ran into: an unsorted input, whose index is returned so it can be
+// sorted, or a read error. The index is -1 otherwise.
+func checkDiffInputs(files []string, inputs []*diffInput, err error) (int, error) {
+	for i, input := range inputs {
+		if input.src.unsorted {
+			return i, errUnsorted
+		}
+		if input.src.failed {
+			return -1, fmt.Errorf("error reading %s: %w", files[i], err)
+		}
+	}
+	return -1, err
+}
+
+// copyEntries adds every entry of src to sorter
+func copyEntries(sorter *entrySorter, src entrySource) error {
+	for {
+		entry, err := src.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := sorter.Add(entry); err != nil {
+			return err
+		}
+	}
 }
 
-// readFileLines reads a file and returns a set of its lines
-func readFileLines(filename string) (map[string]struct{}, error) {
-	set := make(map[string]struct{})
+var errUnsorted = errors.New("inventory is not sorted by path")
 
-	f, err := os.Open(filename)
+// orderedSource checks that entries arrive sorted by path. Repeated paths
+// are collapsed so that the last one wins, as when an inventory is loaded
+// into a map.
+type orderedSource struct {
+	src      entrySource
+	next     *FileEntry // entry read ahead to look for repeats
+	eof      bool
+	last     string
+	started  bool
+	unsorted bool // the input was found out of order
+	failed   bool // the input returned an error
+}
+
+func (o *orderedSource) Next() (FileEntry, error) {
+	entry, err := o.read()
 	if err != nil {
-		return nil, err
+		return FileEntry{}, err
+	}
+	for {
+		following, err := o.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return FileEntry{}, err
+		}
+		if following.Path != entry.Path {
+			o.next = &following
+			break
+		}
+		entry = following
 	}
-	defer f.Close()
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line != "" { // Skip empty lines
-			set[line] = struct{}{}
+	if o.started && entry.Path < o.last {
+		o.unsorted = true
+		return FileEntry{}, errUnsorted
+	}
+	o.last, o.started = entry.Path, true
+	return entry, nil
+}
+
+func (o *orderedSource) read() (FileEntry, error) {
+	if o.next != nil {
+		entry := *o.next
+		o.next = nil
+		return entry, nil
+	}
+	if o.eof {
+		return FileEntry{}, io.EOF
+	}
+	entry, err := o.src.Next()
+	if err == io.EOF {
+		o.eof = true
+	} else if err != nil {
+		o.failed = true
+	}
+	return entry, err
+}
+
+// nextEntry reads from src, turning io.EOF into ok=false
+func nextEntry(src entrySource) (FileEntry, bool, error) {
+	entry, err := src.Next()
+	if err == io.EOF {
+		return FileEntry{}, false, nil
+	}
+	return entry, err == nil, err
+}
+
+// mergeDiff walks two sources sorted by path in step and calls emit for
+// every path that differs, in path order, stopping at the first error emit
+// returns. Paths present in both are reported as modified when any of the
+// given columns differ.
+func mergeDiff(src1, src2 entrySource, columns []string, emit func(diffRow) error) error {
+	entry1, ok1, err := nextEntry(src1)
+	if err != nil {
+		return err
+	}
+	entry2, ok2, err := nextEntry(src2)
+	if err != nil {
+		return err
+	}
+
+	for ok1 || ok2 {
+		switch {
+		case !ok2 || (ok1 && entry1.Path < entry2.Path):
+			if err = emit(diffRow{Path: entry1.Path, Status: statusRemoved, oldEntry: entry1}); err == nil {
+				entry1, ok1, err = nextEntry(src1)
+			}
+		case !ok1 || entry2.Path < entry1.Path:
+			if err = emit(diffRow{Path: entry2.Path, Status: statusAdded, newEntry: entry2}); err == nil {
+				entry2, ok2, err = nextEntry(src2)
+			}
+		default:
+			if changes := compareEntries(entry1, entry2, columns); len(changes) > 0 {
+				err = emit(diffRow{Path: entry1.Path, Status: statusModified, Changes: changes, oldEntry: entry1, newEntry: entry2})
+			}
+			if err != nil {
+				return err
+			}
+			if entry1, ok1, err = nextEntry(src1); err == nil {
+				entry2, ok2, err = nextEntry(src2)
+			}
+		}
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// mergePresence walks sources sorted by path in step and calls emit for
+// every path that some but not all of them list, in path order, stopping at
+// the first error emit returns
+func mergePresence(srcs []entrySource, emit func(diffRow) error) error {
+	heads := make([]FileEntry, len(srcs))
+	ok := make([]bool, len(srcs))
+	for i, src := range srcs {
+		var err error
+		if heads[i], ok[i], err = nextEntry(src); err != nil {
+			return err
+		}
+	}
+
+	for {
+		path, found := "", false
+		for i := range srcs {
+			if ok[i] && (!found || heads[i].Path < path) {
+				path, found = heads[i].Path, true
+			}
+		}
+		if !found {
+			return nil
+		}
+
+		present := make([]bool, len(srcs))
+		everywhere := true
+		for i, src := range srcs {
+			if !ok[i] || heads[i].Path != path {
+				everywhere = false
+				continue
+			}
+			present[i] = true
+			var err error
+			if heads[i], ok[i], err = nextEntry(src); err != nil {
+				return err
+			}
+		}
+		if !everywhere {
+			if err := emit(diffRow{Path: path, Status: statusPartial, Present: present}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// statusMarkers returns the per-inventory markers shown for a status
+func statusMarkers(status string) (string, string) {
+	switch status {
+	case statusRemoved:
+		return "+", "-"
+	case statusAdded:
+		return "-", "+"
+	case statusModified:
+		return "~", "~"
+	case statusRenamed:
+		return "R", "R"
+	default:
+		return " ", " "
+	}
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading file: %w", err)
+// rowMarkers returns the marker shown in each inventory column for row:
+// + or - for presence, ~ for modified and R for renamed paths
+func rowMarkers(row diffRow) []string {
+	if row.Present == nil {
+		file1Status, file2Status := statusMarkers(row.Status)
+		return []string{file1Status, file2Status}
+	}
+	markers := make([]string, len(row.Present))
+	for i, present := range row.Present {
+		markers[i] = "-"
+		if present {
+			markers[i] = "+"
+		}
 	}
+	return markers
+}
 
-	return set, nil
+// computeDiff returns the paths that differ between two inventories, sorted
+// by path. Paths present in both are reported as modified when any of the
+// given columns differ.
+func computeDiff(inv1, inv2 *Inventory, columns []string) []diffRow {
+	var rows []diffRow
+	mergeDiff(sortedSource(inv1), sortedSource(inv2), columns, func(row diffRow) error {
+		rows = append(rows, row)
+		return nil
+	})
+	return rows
+}
+
+// sortedSource returns the entries of an in-memory inventory in path order
+func sortedSource(inv *Inventory) entrySource {
+	entries := append([]FileEntry(nil), inv.Entries...)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return &orderedSource{src: &sliceSource{entries: entries}}
+}
+
+// detectRenames pairs removed and added rows that describe the same file and
+// replaces each pair with a single renamed row. When both inventories carry
+// comparable content hashes, entries with identical digests are paired.
+// Otherwise, if heuristic is set, entries with the same basename and size
+// are. Only unambiguous pairs are reported as renames. Empty files are never
+// paired, as they all look alike, like git does.
+func detectRenames(rows []diffRow, columns []string, heuristic bool) []diffRow {
+	byHash := containsString(columns, AttrHash)
+	if !byHash && !heuristic {
+		return rows
+	}
+
+	withSize := containsString(columns, AttrSize)
+	var oldEntries, newEntries []FileEntry
+	for _, row := range rows {
+		if row.isDir() {
+			// Directories are reported as added and removed, their files as renamed
+			continue
+		}
+		switch row.Status {
+		case statusRemoved:
+			if !isEmptyEntry(row.oldEntry, withSize) {
+				oldEntries = append(oldEntries, row.oldEntry)
+			}
+		case statusAdded:
+			if !isEmptyEntry(row.newEntry, withSize) {
+				newEntries = append(newEntries, row.newEntry)
+			}
+		}
+	}
+	if len(oldEntries) == 0 || len(newEntries) == 0 {
+		return rows
+	}
+
+	// Each key function is tried in turn on the entries still unpaired
+	var keys []func(FileEntry) string
+	if byHash {
+		keys = append(keys,
+			func(e FileEntry) string { return e.Hash },
+			// Duplicate contents are disambiguated by name
+			func(e FileEntry) string {
+				if e.Hash == "" {
+					return ""
+				}
+				return e.Hash + "\x00" + filepath.Base(e.Path)
+			})
+	} else {
+		keys = append(keys, func(e FileEntry) string {
+			if withSize {
+				return filepath.Base(e.Path) + "\x00" + strconv.FormatInt(e.Size, 10)
+			}
+			return filepath.Base(e.Path)
+		})
+	}
+
+	renamedTo := make(map[string]FileEntry) // old path -> new entry
+	paired := make(map[string]bool)         // new paths already paired
+	for _, key := range keys {
+		oldByKey := make(map[string][]FileEntry)
+		newByKey := make(map[string][]FileEntry)
+		for _, e := range oldEntries {
+			if _, done := renamedTo[e.Path]; !done {
+				if k := key(e); k != "" {
+					oldByKey[k] = append(oldByKey[k], e)
+				}
+			}
+		}
+		for _, e := range newEntries {
+			if !paired[e.Path] {
+				if k := key(e); k != "" {
+					newByKey[k] = append(newByKey[k], e)
+				}
+			}
+		}
+		for k, olds := range oldByKey {
+			if news := newByKey[k]; len(olds) == 1 && len(news) == 1 {
+				renamedTo[olds[0].Path] = news[0]
+				paired[news[0].Path] = true
+			}
+		}
+	}
+	if len(renamedTo) == 0 {
+		return rows
+	}
+
+	var result []diffRow
+	for _, row := range rows {
+		if row.Status == statusAdded && paired[row.Path] {
+			continue
+		}
+		if newEntry, ok := renamedTo[row.Path]; ok && row.Status == statusRemoved {
+			result = append(result, diffRow{
+				Path:     newEntry.Path,
+				OldPath:  row.Path,
+				Status:   statusRenamed,
+				Changes:  compareEntries(row.oldEntry, newEntry, columns),
+				oldEntry: row.oldEntry,
+				newEntry: newEntry,
+			})
+			continue
+		}
+		result = append(result, row)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// isEmptyEntry reports whether entry is known to be an empty file, from its
+// size when withSize is set or from its digest
+func isEmptyEntry(entry FileEntry, withSize bool) bool {
+	return (withSize && entry.Size == 0) || isEmptyDigest(entry.Hash)
+}
+
+func entriesByPath(inv *Inventory) map[string]FileEntry {
+	set := make(map[string]FileEntry, len(inv.Entries))
+	for _, entry := range inv.Entries {
+		set[entry.Path] = entry
+	}
+	return set
+}
+
+// comparableColumns returns the attribute columns recorded by both inventories
+func comparableColumns(inv1, inv2 *Inventory) []string {
+	var columns []string
+	for _, col := range inv1.Columns {
+		if inv2.HasColumn(col) {
+			columns = append(columns, col)
+		}
+	}
+	return columns
+}
+
+// compareEntries describes how entry b differs from entry a in the given columns
+func compareEntries(a, b FileEntry, columns []string) []string {
+	var changes []string
+	for _, col := range columns {
+		switch col {
+		case AttrSize:
+			if a.Size != b.Size {
+				changes = append(changes, "size "+strconv.FormatInt(a.Size, 10)+"→"+strconv.FormatInt(b.Size, 10))
+			}
+		case AttrMtime:
+			if !a.ModTime.Equal(b.ModTime) {
+				changes = append(changes, "mtime "+a.ModTime.UTC().Format(time.RFC3339Nano)+"→"+b.ModTime.UTC().Format(time.RFC3339Nano))
+			}
+		case AttrMode:
+			if a.Mode != b.Mode {
+				changes = append(changes, "mode "+formatMode(a.Mode)+"→"+formatMode(b.Mode))
+			}
+		case AttrType:
+			if a.Type != b.Type {
+				changes = append(changes, "type "+a.Type+"→"+b.Type)
+			}
+		case AttrInode:
+			if a.Inode != b.Inode {
+				changes = append(changes, "inode "+strconv.FormatUint(a.Inode, 10)+"→"+strconv.FormatUint(b.Inode, 10))
+			}
+		case AttrTarget:
+			if a.Target != b.Target {
+				changes = append(changes, "target "+a.Target+"→"+b.Target)
+			}
+		case AttrHash:
+			// Digests are only comparable when both sides used the same algorithm
+			if a.Hash != "" && b.Hash != "" && hashAlgorithmOf(a.Hash) == hashAlgorithmOf(b.Hash) && a.Hash != b.Hash {
+				changes = append(changes, "hash changed")
+			}
+		}
+	}
+	return changes
+}
+
+// readFileLines reads an inventory file and returns its entries keyed by path.
+// Both plain path-per-line and record inventories are accepted.
+func readFileLines(filename string) (map[string]FileEntry, error) {
+	inv, err := readInventory(filename)
+	if err != nil {
+		return nil, err
+	}
+	return entriesByPath(inv), nil
 }
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/olekukonko/tablewriter/tw"
+)
+
+// Diff output formats
+const (
+	diffFormatTable    = "table"
+	diffFormatJSON     = "json"
+	diffFormatCSV      = "csv"
+	diffFormatTSV      = "tsv"
+	diffFormatMarkdown = "markdown"
+)
+
+var diffFormats = []string{diffFormatTable, diffFormatJSON, diffFormatCSV, diffFormatMarkdown, diffFormatTSV}
+
+// validateDiffFormat checks that format is a supported diff output format
+func validateDiffFormat(format string) error {
+	if !containsString(diffFormats, format) {
+		return fmt.Errorf("unsupported format %q (supported: %s)", format, strings.Join(diffFormats, ","))
+	}
+	return nil
+}
+
+// renderDiff writes result to w in the given format
+func renderDiff(w io.Writer, result diffResult, format string) error {
+	switch format {
+	case diffFormatJSON:
+		return renderDiffJSON(w, result)
+	case diffFormatCSV, diffFormatTSV:
+		return renderDiffDelimited(w, result, format)
+	case diffFormatMarkdown:
+		return renderDiffMarkdown(w, result)
+	case diffFormatTable, "":
+		return renderDiffTable(w, result)
+	default:
+		return validateDiffFormat(format)
+	}
+}
+
+// diffHeader returns the column headers shared by the tabular formats. The
+// changes column only makes sense when attributes can be compared.
+func diffHeader(result diffResult) []string {
+	header := append([]string{"file_path"}, result.Files...)
+	if len(result.Columns) > 0 {
+		header = append(header, "changes")
+	}
+	return header
+}
+
+// diffCells returns the cells of a row matching diffHeader
+func diffCells(result diffResult, row diffRow) []string {
+	cells := append([]string{row.displayPath()}, rowMarkers(row)...)
+	if len(result.Columns) > 0 {
+		cells = append(cells, strings.Join(row.Changes, ", "))
+	}
+	return cells
+}
+
+func renderDiffTable(w io.Writer, result diffResult) error {
+	if err := renderInventoryHeaders(w, result); err != nil {
+		return err
+	}
+
+	// Create table
+	table := newPlainTable(w)
+	table.Header(diffHeader(result))
+
+	// Add differences to table
+	for _, row := range result.Rows {
+		table.Append(diffCells(result, row))
+	}
+
+	return table.Render()
+}
+
+// newPlainTable returns a table without outer borders, as used by diff and
+// stats, whose headers are printed as given
+func newPlainTable(w io.Writer) *tablewriter.Table {
+	table := tablewriter.NewWriter(w)
+	table.Options(
+		tablewriter.WithHeaderAutoFormat(tw.Off),
+		tablewriter.WithRendition(tw.Rendition{
+			Borders: tw.Border{
+				Left:   tw.Off,
+				Right:  tw.Off,
+				Top:    tw.Off,
+				Bottom: tw.Off,
+			},
+		}),
+	)
+	return table
+}
+
+// renderInventoryHeaders describes the inventories that carry a header, one
+// line each, followed by a blank line
+func renderInventoryHeaders(w io.Writer, result diffResult) error {
+	described := false
+	for i, header := range result.Headers {
+		if header == nil {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\n", result.Files[i], header); err != nil {
+			return err
+		}
+		described = true
+	}
+	if described {
+		_, err := fmt.Fprintln(w)
+		return err
+	}
+	return nil
+}
+
+// renderDiffDelimited writes CSV or TSV with a fixed set of columns so that
+// consumers do not have to care whether attributes were compared
+func renderDiffDelimited(w io.Writer, result diffResult, format string) error {
+	writer := newDelimitedWriter(w, format)
+	if err := writeDelimitedHeader(writer, result); err != nil {
+		return err
+	}
+	for _, row := range result.Rows {
+		if err := writeDelimitedRow(writer, row); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// newDelimitedWriter returns a writer for the csv or tsv format
+func newDelimitedWriter(w io.Writer, format string) *csv.Writer {
+	writer := csv.NewWriter(w)
+	if format == diffFormatTSV {
+		writer.Comma = '\t'
+	}
+	return writer
+}
+
+func writeDelimitedHeader(writer *csv.Writer, result diffResult) error {
+	header := append([]string{"file_path"}, result.Files...)
+	header = append(header, "status", "changes", "old_path")
+	return writer.Write(header)
+}
+
+func writeDelimitedRow(writer *csv.Writer, row diffRow) error {
+	record := append([]string{row.Path}, rowMarkers(row)...)
+	record = append(record, row.Status, strings.Join(row.Changes, "; "), row.OldPath)
+	return writer.Write(record)
+}
+
+func renderDiffMarkdown(w io.Writer, result diffResult) error {
+	header := diffHeader(result)
+	separator := make([]string, len(header))
+	for i := range separator {
+		separator[i] = "---"
+	}
+
+	lines := []string{markdownRow(header), markdownRow(separator)}
+	for _, row := range result.Rows {
+		cells := diffCells(result, row)
+		cells[0] = "`" + strings.ReplaceAll(cells[0], "`", "'") + "`"
+		lines = append(lines, markdownRow(cells))
+	}
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+func markdownRow(cells []string) string {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		escaped[i] = strings.ReplaceAll(cell, "|", `\|`)
+	}
+	return "| " + strings.Join(escaped, " | ") + " |"
+}
+
+// Per-inventory states used in JSON output
+const (
+	statePresent  = "present"
+	stateMissing  = "missing"
+	stateModified = "modified"
+	stateRenamed  = "renamed"
+)
+
+// Kinds of paths in JSON output
+const (
+	kindFile = "file"
+	kindDir  = "dir"
+)
+
+type jsonDiffFile struct {
+	Path    string   `json:"path"`
+	OldPath string   `json:"old_path,omitempty"`
+	Kind    string   `json:"kind"`
+	Status  string   `json:"status"`
+	States  []string `json:"states"` // one per inventory, aligned with jsonDiff.Inventories
+	Changes []string `json:"changes,omitempty"`
+}
+
+type jsonDiffSummary struct {
+	Added    int `json:"added"`
+	Removed  int `json:"removed"`
+	Modified int `json:"modified"`
+	Renamed  int `json:"renamed"`
+	Partial  int `json:"partial,omitempty"` // only when comparing three or more inventories
+	Total    int `json:"total"`
+}
+
+type jsonDiff struct {
+	Inventories []string           `json:"inventories"`
+	Headers     []*inventoryHeader `json:"headers,omitempty"` // aligned with Inventories, null where absent
+	Columns     []string           `json:"compared_columns"`
+	Files       []jsonDiffFile     `json:"files"`
+	Summary     jsonDiffSummary    `json:"summary"`
+}
+
+func renderDiffJSON(w io.Writer, result diffResult) error {
+	doc := jsonDiff{
+		Inventories: result.Files,
+		Columns:     result.Columns,
+		Files:       []jsonDiffFile{},
+	}
+	for _, header := range result.Headers {
+		if header != nil {
+			doc.Headers = result.Headers
+			break
+		}
+	}
+	if doc.Columns == nil {
+		doc.Columns = []string{}
+	}
+
+	for _, row := range result.Rows {
+		file := jsonDiffFile{Path: row.Path, OldPath: row.OldPath, Kind: kindFile, Status: row.Status, Changes: row.Changes}
+		if row.isDir() {
+			file.Kind = kindDir
+		}
+		switch row.Status {
+		case statusAdded:
+			file.States = []string{stateMissing, statePresent}
+			doc.Summary.Added++
+		case statusRemoved:
+			file.States = []string{statePresent, stateMissing}
+			doc.Summary.Removed++
+		case statusModified:
+			file.States = []string{statePresent, stateModified}
+			doc.Summary.Modified++
+		case statusRenamed:
+			file.States = []string{statePresent, stateRenamed}
+			doc.Summary.Renamed++
+		case statusPartial:
+			for _, present := range row.Present {
+				state := stateMissing
+				if present {
+					state = statePresent
+				}
+				file.States = append(file.States, state)
+			}
+			doc.Summary.Partial++
+		}
+		doc.Files = append(doc.Files, file)
+	}
+	doc.Summary.Total = len(result.Rows)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sampleDiffResult() diffResult {
+	return diffResult{
+		Files:   []string{"old.txt", "new.txt"},
+		Columns: []string{AttrSize},
+		Rows: []diffRow{
+			{Path: "added.txt", Status: statusAdded},
+			{Path: "grown.txt", Status: statusModified, Changes: []string{"size 1→2"}},
+			{Path: "pipe|name.txt", Status: statusRemoved},
+		},
+	}
+}
+
+func TestRenderDiffJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := renderDiff(&buf, sampleDiffResult(), diffFormatJSON); err != nil {
+		t.Fatalf("renderDiff failed: %v", err)
+	}
+
+	var doc jsonDiff
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, buf.String())
+	}
+
+	if len(doc.Files) != 3 {
+		t.Fatalf("Expected 3 files, got %d", len(doc.Files))
+	}
+	if strings.Join(doc.Files[0].States, ",") != "missing,present" {
+		t.Errorf("Unexpected states for added file: %v", doc.Files[0].States)
+	}
+	if doc.Files[1].Status != statusModified || len(doc.Files[1].Changes) != 1 {
+		t.Errorf("Unexpected modified entry: %+v", doc.Files[1])
+	}
+
+	expected := jsonDiffSummary{Added: 1, Removed: 1, Modified: 1, Total: 3}
+	if doc.Summary != expected {
+		t.Errorf("Expected summary %+v, got %+v", expected, doc.Summary)
+	}
+}
+
+func TestRenderDiffJSONNoDifferences(t *testing.T) {
+	var buf bytes.Buffer
+	result := diffResult{Files: []string{"a", "b"}}
+	if err := renderDiff(&buf, result, diffFormatJSON); err != nil {
+		t.Fatalf("renderDiff failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"files": []`) {
+		t.Errorf("Expected an empty files array, got %s", buf.String())
+	}
+}
+
+func TestRenderDiffDelimited(t *testing.T) {
+	tests := []struct {
+		format    string
+		delimiter rune
+	}{
+		{diffFormatCSV, ','},
+		{diffFormatTSV, '\t'},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := renderDiff(&buf, sampleDiffResult(), tt.format); err != nil {
+				t.Fatalf("renderDiff failed: %v", err)
+			}
+
+			reader := csv.NewReader(&buf)
+			reader.Comma = tt.delimiter
+			records, err := reader.ReadAll()
+			if err != nil {
+				t.Fatalf("Output is not valid %s: %v", tt.format, err)
+			}
+
+			if len(records) != 4 {
+				t.Fatalf("Expected header and 3 rows, got %d records", len(records))
+			}
+			if strings.Join(records[0], ",") != "file_path,old.txt,new.txt,status,changes,old_path" {
+				t.Errorf("Unexpected header: %v", records[0])
+			}
+			if strings.Join(records[2], ",") != "grown.txt,~,~,modified,size 1→2," {
+				t.Errorf("Unexpected row: %v", records[2])
+			}
+		})
+	}
+}
+
+func TestRenderDiffMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := renderDiff(&buf, sampleDiffResult(), diffFormatMarkdown); err != nil {
+		t.Fatalf("renderDiff failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("Expected 5 lines, got %d:\n%s", len(lines), buf.String())
+	}
+	if lines[0] != "| file_path | old.txt | new.txt | changes |" {
+		t.Errorf("Unexpected header: %s", lines[0])
+	}
+	if lines[1] != "| --- | --- | --- | --- |" {
+		t.Errorf("Unexpected separator: %s", lines[1])
+	}
+	if !strings.Contains(lines[4], `pipe\|name.txt`) {
+		t.Errorf("Pipes in paths should be escaped: %s", lines[4])
+	}
+}
+
+func TestValidateDiffFormat(t *testing.T) {
+	for _, format := range diffFormats {
+		if err := validateDiffFormat(format); err != nil {
+			t.Errorf("Format %s should be accepted: %v", format, err)
+		}
+	}
+	if err := validateDiffFormat("xml"); err == nil {
+		t.Error("Expected error for unsupported format")
+	}
+}
+
+func TestShowDiffWithOptionsWritesToOutput(t *testing.T) {
+	dir := t.TempDir()
+	file1 := filepath.Join(dir, "inv1.txt")
+	file2 := filepath.Join(dir, "inv2.txt")
+	os.WriteFile(file1, []byte("a.txt\nb.txt\n"), 0644)
+	os.WriteFile(file2, []byte("a.txt\nc.txt\n"), 0644)
+
+	var buf bytes.Buffer
+	differ, err := showDiffFiles([]string{file1, file2}, DiffOptions{Format: diffFormatCSV, Output: &buf})
+	if err != nil {
+		t.Fatalf("showDiffFiles failed: %v", err)
+	}
+	if !differ {
+		t.Error("Expected inventories to differ")
+	}
+	if !strings.Contains(buf.String(), "b.txt,+,-,removed,,") || !strings.Contains(buf.String(), "c.txt,-,+,added,,") {
+		t.Errorf("Unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestRenderPresenceMatrix(t *testing.T) {
+	result := diffResult{
+		Files: []string{"a.txt", "b.txt", "c.txt"},
+		Rows: []diffRow{
+			{Path: "etc/motd", Status: statusPartial, Present: []bool{true, false, true}},
+			{Path: "tmp/", Status: statusPartial, Present: []bool{false, false, true}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := renderDiff(&buf, result, diffFormatCSV); err != nil {
+		t.Fatalf("renderDiff failed: %v", err)
+	}
+	expected := "file_path,a.txt,b.txt,c.txt,status,changes,old_path\n" +
+		"etc/motd,+,-,+,partial,,\n" +
+		"tmp/,-,-,+,partial,,\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := renderDiff(&buf, result, diffFormatJSON); err != nil {
+		t.Fatalf("renderDiff failed: %v", err)
+	}
+	var doc jsonDiff
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	if strings.Join(doc.Files[0].States, ",") != "present,missing,present" || doc.Files[1].Kind != kindDir {
+		t.Errorf("Unexpected files: %+v", doc.Files)
+	}
+	if doc.Summary != (jsonDiffSummary{Partial: 2, Total: 2}) {
+		t.Errorf("Unexpected summary: %+v", doc.Summary)
+	}
+}
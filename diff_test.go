@@ -2,9 +2,13 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestShowDiffVariousCases(t *testing.T) {
@@ -194,3 +198,490 @@ func TestReadFileLinesNonexistent(t *testing.T) {
 		t.Error("Expected error for nonexistent file")
 	}
 }
+
+func TestReadFileLinesRecordFormat(t *testing.T) {
+	testFile := "test-read-records.txt"
+	defer os.Remove(testFile)
+
+	content := "#file-inventory 1\n#columns path size\na.txt\t10\nsub/b.txt\t20\n"
+	os.WriteFile(testFile, []byte(content), 0644)
+
+	lines, err := readFileLines(testFile)
+	if err != nil {
+		t.Fatalf("readFileLines failed: %v", err)
+	}
+
+	if len(lines) != 2 {
+		t.Errorf("Expected 2 entries, got %d", len(lines))
+	}
+	if lines["sub/b.txt"].Size != 20 {
+		t.Errorf("Expected size 20 for sub/b.txt, got %d", lines["sub/b.txt"].Size)
+	}
+}
+
+func TestComputeDiffModified(t *testing.T) {
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	inv1 := &Inventory{
+		Columns: []string{AttrSize, AttrMtime, AttrHash},
+		Entries: []FileEntry{
+			{Path: "same.txt", Size: 1, ModTime: mtime, Hash: "sha256:aa"},
+			{Path: "grown.txt", Size: 10, ModTime: mtime, Hash: "sha256:bb"},
+			{Path: "rewritten.txt", Size: 5, ModTime: mtime, Hash: "sha256:cc"},
+			{Path: "gone.txt", Size: 1, ModTime: mtime},
+		},
+	}
+	inv2 := &Inventory{
+		Columns: []string{AttrSize, AttrHash},
+		Entries: []FileEntry{
+			{Path: "same.txt", Size: 1, Hash: "sha256:aa"},
+			{Path: "grown.txt", Size: 42, Hash: "sha256:bb"},
+			{Path: "rewritten.txt", Size: 5, Hash: "sha256:dd"},
+			{Path: "new.txt", Size: 1},
+		},
+	}
+
+	columns := comparableColumns(inv1, inv2)
+	if strings.Join(columns, ",") != "size,hash" {
+		t.Fatalf("Expected comparable columns size,hash, got %v", columns)
+	}
+
+	rows := computeDiff(inv1, inv2, columns)
+	expected := map[string]string{
+		"gone.txt":      statusRemoved,
+		"grown.txt":     statusModified,
+		"new.txt":       statusAdded,
+		"rewritten.txt": statusModified,
+	}
+	if len(rows) != len(expected) {
+		t.Fatalf("Expected %d rows, got %d: %+v", len(expected), len(rows), rows)
+	}
+	for _, row := range rows {
+		if expected[row.Path] != row.Status {
+			t.Errorf("%s: expected status %s, got %s", row.Path, expected[row.Path], row.Status)
+		}
+		switch row.Path {
+		case "grown.txt":
+			if strings.Join(row.Changes, ",") != "size 10→42" {
+				t.Errorf("Unexpected changes for grown.txt: %v", row.Changes)
+			}
+		case "rewritten.txt":
+			if strings.Join(row.Changes, ",") != "hash changed" {
+				t.Errorf("Unexpected changes for rewritten.txt: %v", row.Changes)
+			}
+		}
+	}
+}
+
+func TestCompareEntriesDifferentHashAlgorithms(t *testing.T) {
+	a := FileEntry{Path: "a.txt", Hash: "sha256:aa"}
+	b := FileEntry{Path: "a.txt", Hash: "md5:bb"}
+	if changes := compareEntries(a, b, []string{AttrHash}); len(changes) != 0 {
+		t.Errorf("Digests from different algorithms should not be compared, got %v", changes)
+	}
+}
+
+func TestShowDiffReportsModified(t *testing.T) {
+	file1 := "test-diff1.txt"
+	file2 := "test-diff2.txt"
+	defer os.Remove(file1)
+	defer os.Remove(file2)
+
+	os.WriteFile(file1, []byte("#file-inventory 1\n#columns path size\ncommon.txt\t10\nsame.txt\t3\n"), 0644)
+	os.WriteFile(file2, []byte("#file-inventory 1\n#columns path size\ncommon.txt\t42\nsame.txt\t3\n"), 0644)
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	err := showDiff(file1, file2)
+	if err != nil {
+		t.Fatalf("showDiff failed: %v", err)
+	}
+
+	w.Close()
+	os.Stdout = oldStdout
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	output := buf.String()
+
+	if !strings.Contains(output, "common.txt") || !strings.Contains(output, "size 10→42") {
+		t.Errorf("Output should report modified common.txt: %s", output)
+	}
+	if strings.Contains(output, "same.txt") {
+		t.Errorf("Unchanged file should not be listed: %s", output)
+	}
+}
+
+func TestShowDiffWithOptionsQuiet(t *testing.T) {
+	dir := t.TempDir()
+	file1 := filepath.Join(dir, "inv1.txt")
+	file2 := filepath.Join(dir, "inv2.txt")
+	os.WriteFile(file1, []byte("a.txt\nb.txt\n"), 0644)
+	os.WriteFile(file2, []byte("a.txt\n"), 0644)
+
+	var buf bytes.Buffer
+	differ, err := showDiffFiles([]string{file1, file2}, DiffOptions{Output: &buf, Quiet: true})
+	if err != nil {
+		t.Fatalf("showDiffFiles failed: %v", err)
+	}
+	if !differ {
+		t.Error("Expected inventories to differ")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Quiet mode should print nothing, got %q", buf.String())
+	}
+
+	differ, err = showDiffFiles([]string{file1, file1}, DiffOptions{Output: &buf, Quiet: true})
+	if err != nil {
+		t.Fatalf("showDiffFiles failed: %v", err)
+	}
+	if differ {
+		t.Error("Identical inventories should not differ")
+	}
+}
+
+func TestDetectRenamesByHash(t *testing.T) {
+	inv1 := &Inventory{
+		Columns: []string{AttrSize, AttrHash},
+		Entries: []FileEntry{
+			{Path: "docs/guide.md", Size: 10, Hash: "sha256:aa"},
+			{Path: "old/empty1", Size: 0, Hash: "sha256:e0"},
+			{Path: "old/empty2", Size: 0, Hash: "sha256:e0"},
+			{Path: "gone.txt", Size: 3, Hash: "sha256:cc"},
+			{Path: "unhashed", Size: 0},
+		},
+	}
+	inv2 := &Inventory{
+		Columns: []string{AttrSize, AttrHash},
+		Entries: []FileEntry{
+			{Path: "manual/guide.md", Size: 10, Hash: "sha256:aa"},
+			{Path: "new/empty1", Size: 0, Hash: "sha256:e0"},
+			{Path: "new/empty2", Size: 0, Hash: "sha256:e0"},
+			{Path: "fresh.txt", Size: 3, Hash: "sha256:dd"},
+			{Path: "also-unhashed", Size: 0},
+		},
+	}
+
+	columns := comparableColumns(inv1, inv2)
+	rows := detectRenames(computeDiff(inv1, inv2, columns), columns, false)
+
+	got := make(map[string]string)
+	for _, row := range rows {
+		got[row.displayPath()] = row.Status
+	}
+	expected := map[string]string{
+		"docs/guide.md → manual/guide.md": statusRenamed,
+		"old/empty1":                      statusRemoved,
+		"old/empty2":                      statusRemoved,
+		"new/empty1":                      statusAdded,
+		"new/empty2":                      statusAdded,
+		"gone.txt":                        statusRemoved,
+		"fresh.txt":                       statusAdded,
+		"unhashed":                        statusRemoved,
+		"also-unhashed":                   statusAdded,
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d rows, got %d: %v", len(expected), len(got), got)
+	}
+	for path, status := range expected {
+		if got[path] != status {
+			t.Errorf("%s: expected %s, got %q", path, status, got[path])
+		}
+	}
+}
+
+func TestDetectRenamesSkipsEmptyFiles(t *testing.T) {
+	empty := "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	tests := []struct {
+		name      string
+		columns   []string
+		old, new  FileEntry
+		heuristic bool
+	}{
+		{"empty digest", []string{AttrHash}, FileEntry{Path: "a/.keep", Hash: empty}, FileEntry{Path: "b/__init__.py", Hash: empty}, false},
+		{"zero size", []string{AttrSize, AttrHash}, FileEntry{Path: "a/.keep", Hash: "sha256:e0"}, FileEntry{Path: "b/__init__.py", Hash: "sha256:e0"}, false},
+		{"heuristic", []string{AttrSize}, FileEntry{Path: "a/__init__.py"}, FileEntry{Path: "b/__init__.py"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv1 := &Inventory{Columns: tt.columns, Entries: []FileEntry{tt.old}}
+			inv2 := &Inventory{Columns: tt.columns, Entries: []FileEntry{tt.new}}
+			columns := comparableColumns(inv1, inv2)
+			for _, row := range detectRenames(computeDiff(inv1, inv2, columns), columns, tt.heuristic) {
+				if row.Status == statusRenamed {
+					t.Errorf("Unexpected rename %s", row.displayPath())
+				}
+			}
+		})
+	}
+}
+
+func TestDetectRenamesHeuristic(t *testing.T) {
+	inv1 := &Inventory{
+		Columns: []string{AttrSize},
+		Entries: []FileEntry{
+			{Path: "a/report.pdf", Size: 100},
+			{Path: "a/notes.txt", Size: 5},
+			{Path: "a/dup.txt", Size: 1},
+			{Path: "b/dup.txt", Size: 1},
+		},
+	}
+	inv2 := &Inventory{
+		Columns: []string{AttrSize},
+		Entries: []FileEntry{
+			{Path: "archive/report.pdf", Size: 100},
+			{Path: "archive/notes.txt", Size: 6},
+			{Path: "c/dup.txt", Size: 1},
+			{Path: "d/dup.txt", Size: 1},
+		},
+	}
+
+	columns := comparableColumns(inv1, inv2)
+	rows := computeDiff(inv1, inv2, columns)
+
+	if renamed := detectRenames(rows, columns, false); len(renamed) != len(rows) {
+		t.Errorf("Renames should not be detected without hashes unless the heuristic is enabled")
+	}
+
+	renamed := 0
+	for _, row := range detectRenames(rows, columns, true) {
+		if row.Status != statusRenamed {
+			continue
+		}
+		renamed++
+		if row.OldPath != "a/report.pdf" || row.Path != "archive/report.pdf" {
+			t.Errorf("Unexpected rename %s", row.displayPath())
+		}
+	}
+	if renamed != 1 {
+		t.Errorf("Expected exactly one unambiguous rename, got %d", renamed)
+	}
+}
+
+func TestDiffInventoriesUnsortedInput(t *testing.T) {
+	dir := t.TempDir()
+	sorted := filepath.Join(dir, "sorted.txt")
+	unsorted := filepath.Join(dir, "unsorted.txt")
+	os.WriteFile(sorted, []byte("a.txt\nb.txt\nc.txt\nd/e.txt\n"), 0644)
+	// Out of order, with a repeated path
+	os.WriteFile(unsorted, []byte("d/e.txt\nz.txt\na.txt\nz.txt\nc.txt\n"), 0644)
+
+	for _, budget := range []int64{0, 1} {
+		for _, order := range [][2]string{{sorted, unsorted}, {unsorted, sorted}} {
+			result, err := diffInventories(order[0], order[1], budget, nil)
+			if err != nil {
+				t.Fatalf("diffInventories failed: %v", err)
+			}
+
+			var got []string
+			for _, row := range result.Rows {
+				got = append(got, row.Status+":"+row.Path)
+			}
+			expected := "removed:b.txt,added:z.txt"
+			if order[0] == unsorted {
+				expected = "added:b.txt,removed:z.txt"
+			}
+			if strings.Join(got, ",") != expected {
+				t.Errorf("budget %d: expected %s, got %v", budget, expected, got)
+			}
+		}
+	}
+}
+
+func TestShowDiffFilesStreaming(t *testing.T) {
+	dir := t.TempDir()
+	file1 := filepath.Join(dir, "inv1.txt")
+	file2 := filepath.Join(dir, "inv2.txt")
+	// The second inventory claims to be sorted, but its last path is not
+	sorted := &inventoryHeader{Config: scanSettings{Sorted: true}}
+	writeInventoryWithHeader(file1, formatText, nil, sorted, []FileEntry{{Path: "a"}, {Path: "b"}, {Path: "c"}})
+	writeInventoryWithHeader(file2, formatText, nil, sorted, []FileEntry{{Path: "a"}, {Path: "x"}, {Path: "b"}})
+
+	// A quiet diff stops at b, before reaching the misplaced path
+	differ, err := showDiffFiles([]string{file1, file2}, DiffOptions{Quiet: true})
+	if err != nil || !differ {
+		t.Errorf("Expected a quiet diff to stop at the first difference, got %v, %v", differ, err)
+	}
+
+	// Streamed rows cannot be taken back, so the diff fails instead of
+	// sorting the input and starting over
+	var buf bytes.Buffer
+	_, err = showDiffFiles([]string{file1, file2}, DiffOptions{Format: diffFormatCSV, Output: &buf})
+	if !errors.Is(err, errUnsorted) {
+		t.Errorf("Expected errUnsorted from a streamed diff, got %v", err)
+	}
+
+	// Collected rows can, as when renames are paired
+	buf.Reset()
+	if _, err = showDiffFiles([]string{file1, file2}, DiffOptions{Format: diffFormatCSV, Output: &buf, RenameHeuristic: true}); err != nil {
+		t.Fatalf("showDiffFiles failed: %v", err)
+	}
+	if rows := "\nc,+,-,removed,,\nx,-,+,added,,\n"; !strings.HasSuffix(buf.String(), rows) {
+		t.Errorf("Expected the rows %q, got %q", rows, buf.String())
+	}
+}
+
+func TestShowDiffFilesQuietUnsortedInputs(t *testing.T) {
+	dir := t.TempDir()
+	file1 := filepath.Join(dir, "inv1.txt")
+	file2 := filepath.Join(dir, "inv2.txt")
+	os.WriteFile(file1, []byte("b.txt\na.txt\n"), 0644)
+	os.WriteFile(file2, []byte("a.txt\nb.txt\n"), 0644)
+
+	// Inputs without a sorted header are sorted first, so that the first
+	// difference found is a real one
+	differ, err := showDiffFiles([]string{file1, file2}, DiffOptions{Quiet: true})
+	if err != nil || differ {
+		t.Errorf("Expected no difference, got %v, %v", differ, err)
+	}
+}
+
+func TestOrderedSource(t *testing.T) {
+	src := &orderedSource{src: &sliceSource{entries: []FileEntry{
+		{Path: "a", Size: 1}, {Path: "a", Size: 2}, {Path: "b"}, {Path: "a"},
+	}}}
+
+	entry, err := src.Next()
+	if err != nil || entry.Path != "a" || entry.Size != 2 {
+		t.Fatalf("Expected the last of the repeated entries, got %+v, %v", entry, err)
+	}
+	if entry, err = src.Next(); err != nil || entry.Path != "b" {
+		t.Fatalf("Expected b, got %+v, %v", entry, err)
+	}
+	if _, err = src.Next(); err != errUnsorted || !src.unsorted {
+		t.Errorf("Expected errUnsorted, got %v", err)
+	}
+}
+
+func TestMergeDiffMatchesComputeDiffOnRecords(t *testing.T) {
+	dir := t.TempDir()
+	file1 := filepath.Join(dir, "inv1.txt")
+	file2 := filepath.Join(dir, "inv2.txt")
+	columns := []string{AttrSize}
+	writeInventory(file1, formatText, columns, []FileEntry{{Path: "b", Size: 1}, {Path: "a", Size: 1}, {Path: "c", Size: 3}})
+	writeInventory(file2, formatJSONL, columns, []FileEntry{{Path: "a", Size: 1}, {Path: "c", Size: 4}, {Path: "d", Size: 1}})
+
+	result, err := diffInventories(file1, file2, 0, nil)
+	if err != nil {
+		t.Fatalf("diffInventories failed: %v", err)
+	}
+
+	inv1, _ := readInventory(file1)
+	inv2, _ := readInventory(file2)
+	expected := computeDiff(inv1, inv2, comparableColumns(inv1, inv2))
+	if len(result.Rows) != len(expected) {
+		t.Fatalf("Expected %d rows, got %d", len(expected), len(result.Rows))
+	}
+	for i := range expected {
+		a, b := expected[i], result.Rows[i]
+		if a.Path != b.Path || a.Status != b.Status || strings.Join(a.Changes, ",") != strings.Join(b.Changes, ",") {
+			t.Errorf("Row %d: expected %+v, got %+v", i, a, b)
+		}
+	}
+}
+
+func TestShowDiffDirectories(t *testing.T) {
+	dir := t.TempDir()
+	file1 := filepath.Join(dir, "inv1.txt")
+	file2 := filepath.Join(dir, "inv2.txt")
+	os.WriteFile(file1, []byte("a/\na/x/\na/x/f.txt\nold-empty/\n"), 0644)
+	os.WriteFile(file2, []byte("a/\nb/x/\nb/x/f.txt\nnew-empty/\n"), 0644)
+
+	var buf bytes.Buffer
+	_, err := showDiffFiles([]string{file1, file2}, DiffOptions{Format: diffFormatJSON, Output: &buf, RenameHeuristic: true})
+	if err != nil {
+		t.Fatalf("showDiffFiles failed: %v", err)
+	}
+
+	var doc jsonDiff
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Output is not valid JSON: %v", err)
+	}
+	var got []string
+	for _, f := range doc.Files {
+		got = append(got, f.Kind+":"+f.Status+":"+f.Path)
+	}
+	// The file is paired as a rename, the directories never are
+	expected := "dir:removed:a/x/,dir:added:b/x/,file:renamed:b/x/f.txt,dir:added:new-empty/,dir:removed:old-empty/"
+	if strings.Join(got, ",") != expected {
+		t.Errorf("Expected %s, got %s", expected, strings.Join(got, ","))
+	}
+}
+
+func TestMergePresence(t *testing.T) {
+	srcs := []entrySource{
+		&sliceSource{entries: []FileEntry{{Path: "a"}, {Path: "b"}, {Path: "c"}}},
+		&sliceSource{entries: []FileEntry{{Path: "a"}, {Path: "c"}, {Path: "d"}}},
+		&sliceSource{entries: []FileEntry{{Path: "a"}, {Path: "b"}}},
+	}
+
+	var got []string
+	err := mergePresence(srcs, func(row diffRow) error {
+		got = append(got, row.Path+":"+strings.Join(rowMarkers(row), ""))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("mergePresence failed: %v", err)
+	}
+	// a is everywhere and left out
+	expected := "b:+-+,c:++-,d:-+-"
+	if strings.Join(got, ",") != expected {
+		t.Errorf("Expected %s, got %s", expected, strings.Join(got, ","))
+	}
+}
+
+func TestShowDiffFilesPresenceMatrix(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		filepath.Join(dir, "web1.txt"),
+		filepath.Join(dir, "web2.txt"),
+		filepath.Join(dir, "web3.jsonl"),
+		filepath.Join(dir, "web4.txt"),
+	}
+	os.WriteFile(files[0], []byte("etc/hosts\netc/motd\nopt/app\n"), 0644)
+	// Unsorted inputs are sorted on disk like for two-way diffs
+	os.WriteFile(files[1], []byte("opt/app\netc/hosts\n"), 0644)
+	writeInventory(files[2], formatJSONL, []string{AttrSize}, []FileEntry{{Path: "etc/hosts", Size: 1}, {Path: "opt/app", Size: 2}})
+	os.WriteFile(files[3], []byte("etc/hosts\nopt/app\ntmp/debug.log\n"), 0644)
+
+	result, err := presenceMatrix(files, 0, nil)
+	if err != nil {
+		t.Fatalf("presenceMatrix failed: %v", err)
+	}
+	if len(result.Columns) != 0 {
+		t.Errorf("Expected no compared columns, got %v", result.Columns)
+	}
+	var got []string
+	for _, row := range result.Rows {
+		got = append(got, row.Status+":"+row.Path+":"+strings.Join(rowMarkers(row), ""))
+	}
+	expected := "partial:etc/motd:+---,partial:tmp/debug.log:---+"
+	if strings.Join(got, ",") != expected {
+		t.Errorf("Expected %s, got %s", expected, strings.Join(got, ","))
+	}
+
+	var buf bytes.Buffer
+	differ, err := showDiffFiles(files, DiffOptions{Output: &buf})
+	if err != nil {
+		t.Fatalf("showDiffFiles failed: %v", err)
+	}
+	if !differ {
+		t.Error("Expected inventories to differ")
+	}
+	output := buf.String()
+	for _, file := range files {
+		if !strings.Contains(output, file) {
+			t.Errorf("Expected a column for %s:\n%s", file, output)
+		}
+	}
+	if strings.Contains(output, "opt/app") || strings.Contains(output, "changes") {
+		t.Errorf("Unexpected rows or columns:\n%s", output)
+	}
+
+	// Identical inventories have nothing to show
+	same := []string{files[1], files[1], files[1]}
+	if differ, err := showDiffFiles(same, DiffOptions{Quiet: true}); err != nil || differ {
+		t.Errorf("Expected no differences, got %v, %v", differ, err)
+	}
+}
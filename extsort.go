@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// defaultSortMemory is the memory budget used by --sort unless --sort-mem is given
+const defaultSortMemory = 256 << 20
+
+// entryOverhead approximates the memory used by a FileEntry besides its strings
+const entryOverhead = 128
+
+// maxMergeRuns is the most runs merged at once, which bounds the number of
+// open files. More runs are first merged in groups into longer ones.
+const maxMergeRuns = 64
+
+// entrySorter sorts entries by path within a memory budget. Entries are
+// buffered until the budget is reached, then sorted and spilled to a
+// temporary file as a run; the runs are k-way merged when reading back.
+type entrySorter struct {
+	columns []string // columns to keep when spilling
+	budget  int64
+	used    int64
+	buf     []FileEntry
+	tempDir string
+	runs    []string
+	written int        // runs written so far, for naming them
+	open    []*os.File // runs being merged
+}
+
+// newEntrySorter returns a sorter keeping the given columns of each entry,
+// using about budget bytes of memory before spilling to disk
+func newEntrySorter(columns []string, budget int64) *entrySorter {
+	if budget <= 0 {
+		budget = defaultSortMemory
+	}
+	return &entrySorter{columns: columns, budget: budget}
+}
+
+// Add buffers entry, spilling a sorted run to disk when the budget is exceeded
+func (s *entrySorter) Add(entry FileEntry) error {
+	s.buf = append(s.buf, entry)
+	s.used += entrySize(entry)
+	if s.used >= s.budget {
+		return s.spill()
+	}
+	return nil
+}
+
+func entrySize(entry FileEntry) int64 {
+	return int64(entryOverhead + len(entry.Path) + len(entry.Hash) + len(entry.srcPath))
+}
+
+func (s *entrySorter) sortBuffer() {
+	sort.SliceStable(s.buf, func(i, j int) bool { return s.buf[i].Path < s.buf[j].Path })
+}
+
+// spill writes the buffered entries to a new sorted run
+func (s *entrySorter) spill() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	if s.tempDir == "" {
+		dir, err := os.MkdirTemp("", "file-inventory-sort-")
+		if err != nil {
+			return fmt.Errorf("failed to create sort directory: %w", err)
+		}
+		s.tempDir = dir
+	}
+
+	s.sortBuffer()
+	name, err := s.writeRun(&sliceSource{entries: s.buf})
+	if err != nil {
+		return err
+	}
+
+	s.runs = append(s.runs, name)
+	s.buf = s.buf[:0]
+	s.used = 0
+	return nil
+}
+
+// writeRun writes the entries of src, which must be sorted, to a new run
+// and returns its name
+func (s *entrySorter) writeRun(src entrySource) (string, error) {
+	name := filepath.Join(s.tempDir, "run"+strconv.Itoa(s.written))
+	s.written++
+	f, err := os.Create(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to create sort run: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for {
+		entry, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, err := fmt.Fprintln(w, formatRecord(entry, s.columns)); err != nil {
+			return "", fmt.Errorf("failed to write sort run: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("failed to write sort run: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to write sort run: %w", err)
+	}
+	return name, nil
+}
+
+// Each calls fn for every entry in path order, stopping at the first error.
+// Entries read back from disk only carry the sorter's columns.
+func (s *entrySorter) Each(fn func(FileEntry) error) error {
+	src, err := s.Sorted()
+	if err != nil {
+		return err
+	}
+	for {
+		entry, err := src.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// Sorted returns the entries added so far in path order. No more entries
+// may be added afterwards.
+func (s *entrySorter) Sorted() (entrySource, error) {
+	if len(s.runs) == 0 {
+		s.sortBuffer()
+		return &sliceSource{entries: s.buf}, nil
+	}
+
+	// Spill the remainder too, so the merge only deals with runs
+	if err := s.spill(); err != nil {
+		return nil, err
+	}
+
+	for len(s.runs) > maxMergeRuns {
+		if err := s.mergePass(); err != nil {
+			return nil, err
+		}
+	}
+	return s.merge(s.runs)
+}
+
+// mergePass merges consecutive groups of at most maxMergeRuns runs into one
+// run each. Groups keep the order of the runs, so equal paths keep their
+// insertion order.
+func (s *entrySorter) mergePass() error {
+	var merged []string
+	for start := 0; start < len(s.runs); start += maxMergeRuns {
+		group := s.runs[start:min(start+maxMergeRuns, len(s.runs))]
+		if len(group) == 1 {
+			merged = append(merged, group[0])
+			continue
+		}
+
+		src, err := s.merge(group)
+		if err != nil {
+			return err
+		}
+		name, err := s.writeRun(src)
+		if err != nil {
+			return err
+		}
+		for _, f := range s.open {
+			f.Close()
+		}
+		s.open = nil
+		for _, old := range group {
+			os.Remove(old)
+		}
+		merged = append(merged, name)
+	}
+	s.runs = merged
+	return nil
+}
+
+// merge opens the given runs and k-way merges them
+func (s *entrySorter) merge(runs []string) (*mergeSource, error) {
+	h := &runHeap{}
+	for i, name := range runs {
+		r, err := openRun(name, i, s.columns)
+		if err != nil {
+			return nil, err
+		}
+		s.open = append(s.open, r.f)
+
+		ok, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			h.runs = append(h.runs, r)
+		}
+	}
+	heap.Init(h)
+	return &mergeSource{heap: h}, nil
+}
+
+// sliceSource yields the entries of a slice
+type sliceSource struct {
+	entries []FileEntry
+}
+
+func (s *sliceSource) Next() (FileEntry, error) {
+	if len(s.entries) == 0 {
+		return FileEntry{}, io.EOF
+	}
+	entry := s.entries[0]
+	s.entries = s.entries[1:]
+	return entry, nil
+}
+
+// mergeSource k-way merges sorted runs
+type mergeSource struct {
+	heap    *runHeap
+	advance bool // the smallest run's entry was returned and must be replaced
+}
+
+func (m *mergeSource) Next() (FileEntry, error) {
+	h := m.heap
+	if m.advance {
+		m.advance = false
+		ok, err := h.runs[0].next()
+		if err != nil {
+			return FileEntry{}, err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+
+	if h.Len() == 0 {
+		return FileEntry{}, io.EOF
+	}
+	m.advance = true
+	return h.runs[0].entry, nil
+}
+
+// Close removes the temporary runs
+func (s *entrySorter) Close() error {
+	for _, f := range s.open {
+		f.Close()
+	}
+	s.open = nil
+	s.buf = nil
+	s.runs = nil
+	if s.tempDir == "" {
+		return nil
+	}
+	dir := s.tempDir
+	s.tempDir = ""
+	return os.RemoveAll(dir)
+}
+
+// sortRun reads back one spilled run
+type sortRun struct {
+	f       *os.File
+	scanner *bufio.Scanner
+	index   int // breaks ties so equal paths keep their insertion order
+	columns []string
+	entry   FileEntry
+}
+
+func openRun(name string, index int, columns []string) (*sortRun, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sort run: %w", err)
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &sortRun{f: f, scanner: scanner, index: index, columns: columns}, nil
+}
+
+// next advances to the following entry, returning false at the end of the run
+func (r *sortRun) next() (bool, error) {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return false, fmt.Errorf("failed to read sort run: %w", err)
+		}
+		return false, nil
+	}
+
+	entry, err := parseRecord(r.scanner.Text(), r.columns)
+	if err != nil {
+		return false, fmt.Errorf("corrupt sort run: %w", err)
+	}
+	r.entry = entry
+	return true, nil
+}
+
+// runHeap orders runs by their current entry
+type runHeap struct {
+	runs []*sortRun
+}
+
+func (h *runHeap) Len() int { return len(h.runs) }
+
+func (h *runHeap) Less(i, j int) bool {
+	a, b := h.runs[i], h.runs[j]
+	if a.entry.Path != b.entry.Path {
+		return a.entry.Path < b.entry.Path
+	}
+	return a.index < b.index
+}
+
+func (h *runHeap) Swap(i, j int) { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+
+func (h *runHeap) Push(x any) { h.runs = append(h.runs, x.(*sortRun)) }
+
+func (h *runHeap) Pop() any {
+	r := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return r
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestEntrySorter(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var input []FileEntry
+	for i := 0; i < 500; i++ {
+		input = append(input, FileEntry{
+			Path:    fmt.Sprintf("dir%d/file\t%04d.txt", rng.Intn(10), rng.Intn(10000)),
+			Size:    int64(i),
+			ModTime: time.Unix(int64(i), 0).UTC(),
+			Hash:    fmt.Sprintf("md5:%032x", i),
+		})
+	}
+
+	tests := []struct {
+		name        string
+		budget      int64
+		expectSpill bool
+	}{
+		{"in memory", 1 << 20, false},
+		{"spilled", 4096, true},
+		{"one entry per run", 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorter := newEntrySorter([]string{AttrSize, AttrMtime, AttrHash}, tt.budget)
+			for _, entry := range input {
+				if err := sorter.Add(entry); err != nil {
+					t.Fatalf("Add failed: %v", err)
+				}
+			}
+			if spilled := len(sorter.runs) > 0; spilled != tt.expectSpill {
+				t.Errorf("Expected spilled=%v", tt.expectSpill)
+			}
+			tempDir := sorter.tempDir
+
+			var output []FileEntry
+			err := sorter.Each(func(entry FileEntry) error {
+				output = append(output, entry)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Each failed: %v", err)
+			}
+			if err := sorter.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			if len(output) != len(input) {
+				t.Fatalf("Expected %d entries, got %d", len(input), len(output))
+			}
+			if !sort.SliceIsSorted(output, func(i, j int) bool { return output[i].Path < output[j].Path }) {
+				t.Error("Output is not sorted")
+			}
+			for _, entry := range output {
+				want := input[entry.Size]
+				if entry.Path != want.Path || !entry.ModTime.Equal(want.ModTime) || entry.Hash != want.Hash {
+					t.Errorf("Entry did not survive the round trip: expected %+v, got %+v", want, entry)
+					break
+				}
+			}
+
+			if tempDir != "" {
+				if _, err := os.Stat(tempDir); !os.IsNotExist(err) {
+					t.Errorf("Temporary directory %s was not removed", tempDir)
+				}
+			}
+		})
+	}
+}
+
+func TestEntrySorterManyRuns(t *testing.T) {
+	// One entry per run, so there are more runs than can be merged at once
+	sorter := newEntrySorter([]string{AttrSize}, 1)
+	defer sorter.Close()
+	n := 3*maxMergeRuns + 1
+	for i := 0; i < n; i++ {
+		if err := sorter.Add(FileEntry{Path: fmt.Sprintf("file%d", i%7), Size: int64(i)}); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	src, err := sorter.Sorted()
+	if err != nil {
+		t.Fatalf("Sorted failed: %v", err)
+	}
+	if len(sorter.open) > maxMergeRuns {
+		t.Errorf("Expected at most %d open runs, got %d", maxMergeRuns, len(sorter.open))
+	}
+	if files, _ := os.ReadDir(sorter.tempDir); len(files) > maxMergeRuns {
+		t.Errorf("Expected merged runs to be removed, %d left", len(files))
+	}
+
+	var output []FileEntry
+	for {
+		entry, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		output = append(output, entry)
+	}
+	if len(output) != n {
+		t.Fatalf("Expected %d entries, got %d", n, len(output))
+	}
+	// Equal paths keep their insertion order across merge passes
+	sorted := sort.SliceIsSorted(output, func(i, j int) bool {
+		if output[i].Path != output[j].Path {
+			return output[i].Path < output[j].Path
+		}
+		return output[i].Size < output[j].Size
+	})
+	if !sorted {
+		t.Error("Output is not sorted by path and insertion order")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+)
+
+// fileIDOf identifies the file at path by its path with every symlink
+// resolved, since inode numbers are not available here
+func fileIDOf(path string, info fs.FileInfo) (fileID, bool) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fileID{}, false
+	}
+	return fileID{path: resolved}, true
+}
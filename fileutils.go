@@ -2,21 +2,55 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
-	"io/fs"
 	"os"
 	"path/filepath"
-	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Config holds configuration options for file operations
 type Config struct {
-	SortOutput    bool
-	RelativePaths bool
-	IncludeHidden bool
-	ExcludePatterns []string
-	IncludePatterns []string
+	SortOutput       bool
+	RelativePaths    bool
+	Label            string // prefix for relative paths, when scanning several roots
+	IncludeHidden    bool
+	ExcludePatterns  []string
+	IncludePatterns  []string
+	Attributes       []string     // per-file attributes to record, see knownAttributes
+	HashAlgorithm    string       // content digest to compute, empty to skip hashing
+	Reuse            *digestCache // digests of a previous inventory to reuse, or nil
+	Jobs             int          // hashing workers, defaults to the number of CPUs
+	Format           string       // output format: text, json or jsonl
+	RespectGitignore bool         // also honour .gitignore files, not just .inventoryignore
+	Walkers          int          // directories read in parallel; 0 or 1 walks serially
+	SortMemory       int64        // bytes --sort may buffer before spilling to disk, 0 for the default
+	Symlinks         string       // skip, record or follow; empty lists links like other files
+	Dirs             bool         // also list directories, with a trailing slash
+	Types            []string     // type letters to include (see fileTypes), empty for all
+	MinSize          int64        // smallest size to include, in bytes
+	MaxSize          int64        // largest size to include, if HasMaxSize
+	HasMaxSize       bool         // MaxSize applies; a MaxSize of 0 then selects empty entries
+	NewerThan        time.Time    // only include entries changed after this, if set
+	OlderThan        time.Time    // only include entries changed before this, if set
+	TimeField        string       // timestamp compared by NewerThan/OlderThan: mtime (default) or ctime
+	Header           bool         // start the inventory with a header describing the scan
+	Skip             []string     // absolute paths left out, such as the inventory being written
+}
+
+// Columns returns the inventory columns produced by this config
+func (c Config) Columns() []string {
+	columns := append([]string(nil), c.Attributes...)
+	if c.Symlinks == symlinksRecord || c.Symlinks == symlinksFollow {
+		columns = append(columns, AttrTarget)
+	}
+	if c.HashAlgorithm != "" {
+		columns = append(columns, AttrHash)
+	}
+	return columns
 }
 
 func writeFileList(filename string, files []string) error {
@@ -37,109 +71,235 @@ func writeFileList(filename string, files []string) error {
 	return nil
 }
 
-func findFiles(dirPath string) ([]string, error) {
-	return findFilesWithConfig(dirPath, Config{RelativePaths: true})
-}
-
-func findFilesWithConfig(dirPath string, config Config) ([]string, error) {
+// scanRoot validates dirPath and returns it as an absolute path
+func scanRoot(dirPath string) (string, error) {
 	// Validate input directory
 	if info, err := os.Stat(dirPath); err != nil {
-		return nil, fmt.Errorf("cannot access directory: %w", err)
+		return "", fmt.Errorf("cannot access directory: %w", err)
 	} else if !info.IsDir() {
-		return nil, fmt.Errorf("%q is not a directory", dirPath)
+		return "", fmt.Errorf("%q is not a directory", dirPath)
 	}
 
 	// Convert to absolute path for consistent behavior
 	absDirPath, err := filepath.Abs(dirPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+		return "", fmt.Errorf("failed to get absolute path: %w", err)
 	}
+	return absDirPath, nil
+}
 
-	var files []string
-	var count int
+// labeledRoot is a directory to scan and the label that prefixes its
+// relative paths, if any
+type labeledRoot struct {
+	Label string `json:"label,omitempty"`
+	Path  string `json:"path"`
+}
+
+// parseRootArg parses a root given as DIR or LABEL=DIR. A label cannot
+// contain a path separator, so DIR alone may contain "=".
+func parseRootArg(arg string) (labeledRoot, error) {
+	label, path, ok := strings.Cut(arg, "=")
+	if !ok || label == "" || strings.ContainsRune(label, '/') || strings.ContainsRune(label, filepath.Separator) {
+		return labeledRoot{Path: arg}, nil
+	}
+	if label == "." || label == ".." {
+		return labeledRoot{}, fmt.Errorf("invalid label %q in %q", label, arg)
+	}
+	if path == "" {
+		return labeledRoot{}, fmt.Errorf("missing directory for label %q", label)
+	}
+	return labeledRoot{Label: label, Path: path}, nil
+}
+
+// String returns the root in the form parseRootArg accepts
+func (r labeledRoot) String() string {
+	if r.Label == "" {
+		return r.Path
+	}
+	return r.Label + "=" + r.Path
+}
 
-	err = filepath.WalkDir(absDirPath, func(path string, d fs.DirEntry, err error) error {
+// resolveRoots validates roots and makes their paths absolute. When there
+// are several, unlabeled roots are labeled with their base name so that
+// their relative paths stay apart.
+func resolveRoots(roots []labeledRoot) ([]labeledRoot, error) {
+	resolved := make([]labeledRoot, len(roots))
+	seen := make(map[string]string)
+	for i, root := range roots {
+		absPath, err := scanRoot(root.Path)
 		if err != nil {
-			// Log warning but continue processing
-			fmt.Fprintf(os.Stderr, "Warning: skipping %q: %v\n", path, err)
-			return nil
+			return nil, fmt.Errorf("failed to scan directory %q: %w", root.Path, err)
 		}
-
-		if d.IsDir() {
-			return nil
+		label := root.Label
+		if label == "" && len(roots) > 1 {
+			label = filepath.Base(absPath)
 		}
-
-		// Skip hidden files if not included
-		if !config.IncludeHidden && isHidden(path) {
-			return nil
+		if other, ok := seen[label]; ok {
+			return nil, fmt.Errorf("%q and %q have the same label %q", other, root.Path, label)
 		}
+		seen[label] = root.Path
+		resolved[i] = labeledRoot{Label: label, Path: absPath}
+	}
+	return resolved, nil
+}
 
-		// Apply include/exclude patterns
-		if !shouldIncludeFile(path, config) {
-			return nil
+// scanDirectories scans several roots concurrently, like scanDirectory does
+// for one, and passes the entries of all of them to emit. Relative paths
+// are prefixed with the label of their root. emit is never called
+// concurrently, and entries of different roots may come in any order.
+func scanDirectories(roots []labeledRoot, config Config, emit func(FileEntry) error) error {
+	errs := make([]error, len(roots))
+	var valid []labeledRoot
+	var validIndex []int
+	for i, root := range roots {
+		absPath, err := scanRoot(root.Path)
+		if err != nil {
+			errs[i] = fmt.Errorf("failed to scan directory %q: %w", root.Path, err)
+			continue
 		}
+		valid = append(valid, labeledRoot{Label: root.Label, Path: absPath})
+		validIndex = append(validIndex, i)
+	}
 
-		// Convert to relative path if requested
-		finalPath := path
-		if config.RelativePaths {
-			if relPath, err := filepath.Rel(absDirPath, path); err == nil {
-				finalPath = relPath
-			}
+	walkErrs, err := walkRoots(valid, config, emit)
+	if err != nil {
+		// A failed emit stops every scan; report it once
+		return err
+	}
+	for j, walkErr := range walkErrs {
+		if walkErr != nil {
+			i := validIndex[j]
+			errs[i] = fmt.Errorf("failed to scan directory %q: %w", roots[i].Path, walkErr)
 		}
+	}
+	return errors.Join(errs...)
+}
+
+// scanDirectory walks dirPath and passes every included entry to emit as
+// soon as it is ready, hashing files on the way if config asks for it.
+// Entries are not collected, so memory use does not grow with the tree.
+// config.SortOutput is ignored; sorting is up to the consumer. The scan stops
+// at the first error returned by emit.
+func scanDirectory(dirPath string, config Config, emit func(FileEntry) error) error {
+	absDirPath, err := scanRoot(dirPath)
+	if err != nil {
+		return err
+	}
 
-		files = append(files, finalPath)
+	walkErrs, err := walkRoots([]labeledRoot{{Label: config.Label, Path: absDirPath}}, config, emit)
+	if err == nil {
+		err = walkErrs[0]
+	}
+	if err != nil {
+		return fmt.Errorf("error walking directory: %w", err)
+	}
+	return nil
+}
+
+// walkRoots walks roots, which must be absolute, concurrently and passes
+// their entries to emit through a single hash pipeline, so that --jobs
+// bounds the hashing workers however many roots there are. emit is never
+// called concurrently, and progress is counted over all roots. It returns
+// the error emit failed with, or else the walk error of each root.
+func walkRoots(roots []labeledRoot, config Config, emit func(FileEntry) error) ([]error, error) {
+	var (
+		mu      sync.Mutex
+		count   int
+		emitErr error
+	)
+	counted := func(entry FileEntry) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if emitErr != nil {
+			return emitErr
+		}
 		count++
 
 		// Show progress for large directories
 		if count%1000 == 0 {
 			fmt.Fprintf(os.Stderr, "Found %d files...\r", count)
 		}
+		emitErr = emit(entry)
+		return emitErr
+	}
 
-		return nil
-	})
+	// Hash in a pipeline between the walks and the consumer, keeping the
+	// order in which entries were found
+	visit := counted
+	var hashing *hashPipeline
+	if config.HashAlgorithm != "" {
+		hashing = newHashPipeline(config.HashAlgorithm, config.Jobs, config.Reuse, counted)
+		visit = hashing.add
+	}
+
+	errs := make([]error, len(roots))
+	var wg sync.WaitGroup
+	for i, root := range roots {
+		rootConfig := config
+		rootConfig.Label = root.Label
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := newScanner(root.Path, rootConfig)
+			if config.Walkers > 1 {
+				errs[i] = s.walkParallel(config.Walkers, visit)
+			} else {
+				errs[i] = s.walkSerial(visit)
+			}
+		}()
+	}
+	wg.Wait()
+	if hashing != nil {
+		// The pipeline only fails when emit does, which emitErr holds
+		hashing.close()
+	}
 
 	if count > 0 && count%1000 == 0 {
 		fmt.Fprintf(os.Stderr, "\n")
 	}
+	return errs, emitErr
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("error walking directory: %w", err)
+// formatByteSize formats n bytes for people, e.g. "512 B" or "1.5 MiB"
+func formatByteSize(n int64) string {
+	if n < 1024 {
+		return strconv.FormatInt(n, 10) + " B"
 	}
-
-	// Sort output if requested
-	if config.SortOutput {
-		sort.Strings(files)
+	value, unit := float64(n)/1024, 0
+	for value >= 1024 && unit < 4 {
+		value /= 1024
+		unit++
 	}
-
-	return files, nil
+	return strconv.FormatFloat(value, 'f', 1, 64) + " " + []string{"KiB", "MiB", "GiB", "TiB", "PiB"}[unit]
 }
 
-func isHidden(path string) bool {
-	base := filepath.Base(path)
-	return strings.HasPrefix(base, ".")
-}
+// parseByteSize parses a size such as "512", "64K", "256M" or "2G". Units are
+// powers of 1024 and may be followed by "B" or "iB".
+func parseByteSize(s string) (int64, error) {
+	value := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	if n := len(value); n >= 2 && value[n-1] == 'I' && strings.IndexByte("KMGT", value[n-2]) >= 0 {
+		value = value[:n-1]
+	}
 
-func shouldIncludeFile(path string, config Config) bool {
-	// If include patterns are specified, file must match at least one
-	if len(config.IncludePatterns) > 0 {
-		matched := false
-		for _, pattern := range config.IncludePatterns {
-			if match, _ := filepath.Match(pattern, filepath.Base(path)); match {
-				matched = true
-				break
-			}
-		}
-		if !matched {
-			return false
+	multiplier := int64(1)
+	if n := len(value); n > 0 {
+		if shift := strings.IndexByte("KMGT", value[n-1]); shift >= 0 {
+			multiplier = 1 << (10 * (shift + 1))
+			value = value[:n-1]
 		}
 	}
 
-	// If exclude patterns are specified, file must not match any
-	for _, pattern := range config.ExcludePatterns {
-		if match, _ := filepath.Match(pattern, filepath.Base(path)); match {
-			return false
-		}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
 	}
+	if n > (1<<63-1)/multiplier {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return n * multiplier, nil
+}
 
-	return true
+func isHidden(path string) bool {
+	base := filepath.Base(path)
+	return strings.HasPrefix(base, ".")
 }
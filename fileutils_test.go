@@ -1,12 +1,45 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 )
 
+// scanEntries collects the entries scanDirectory finds under dirPath, sorted
+// by path when config.SortOutput is set
+func scanEntries(dirPath string, config Config) ([]FileEntry, error) {
+	var entries []FileEntry
+	err := scanDirectory(dirPath, config, func(entry FileEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if config.SortOutput {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	}
+	return entries, nil
+}
+
+// scanPaths returns the paths of the entries scanEntries collects
+func scanPaths(dirPath string, config Config) ([]string, error) {
+	entries, err := scanEntries(dirPath, config)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(entries))
+	for i, entry := range entries {
+		paths[i] = entry.Path
+	}
+	return paths, nil
+}
+
 func TestFindFilesVariousCases(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -78,7 +111,7 @@ func TestFindFilesVariousCases(t *testing.T) {
 				os.WriteFile(fullPath, []byte("test"), 0644)
 			}
 
-			files, err := findFilesWithConfig(dir, tt.config)
+			files, err := scanPaths(dir, tt.config)
 
 			if tt.expectError && err == nil {
 				t.Error("Expected error but got none")
@@ -113,9 +146,9 @@ func TestFindFiles(t *testing.T) {
 	os.WriteFile(file1, []byte("test1"), 0644)
 	os.WriteFile(file2, []byte("test2"), 0644)
 
-	files, err := findFiles(dir)
+	files, err := scanPaths(dir, Config{RelativePaths: true})
 	if err != nil {
-		t.Fatalf("findFiles failed: %v", err)
+		t.Fatalf("scanPaths failed: %v", err)
 	}
 
 	if len(files) != 2 {
@@ -143,7 +176,7 @@ func TestFindFiles(t *testing.T) {
 }
 
 func TestFindFilesInvalidDirectory(t *testing.T) {
-	_, err := findFiles("/nonexistent/directory")
+	_, err := scanPaths("/nonexistent/directory", Config{RelativePaths: true})
 	if err == nil {
 		t.Error("Expected error for nonexistent directory")
 	}
@@ -154,7 +187,7 @@ func TestFindFilesNotADirectory(t *testing.T) {
 	tempFile := filepath.Join(t.TempDir(), "notadir.txt")
 	os.WriteFile(tempFile, []byte("test"), 0644)
 
-	_, err := findFiles(tempFile)
+	_, err := scanPaths(tempFile, Config{RelativePaths: true})
 	if err == nil {
 		t.Error("Expected error when path is not a directory")
 	}
@@ -169,9 +202,9 @@ func TestInventoryDoesNotIncludeDirectories(t *testing.T) {
 	os.WriteFile(file1, []byte("test1"), 0644)
 	os.WriteFile(file2, []byte("test2"), 0644)
 
-	files, err := findFiles(dir)
+	files, err := scanPaths(dir, Config{RelativePaths: true})
 	if err != nil {
-		t.Fatalf("findFiles failed: %v", err)
+		t.Fatalf("scanPaths failed: %v", err)
 	}
 	for _, f := range files {
 		// Convert relative path to absolute for stat check
@@ -254,9 +287,9 @@ func TestRelativePaths(t *testing.T) {
 	os.WriteFile(file2, []byte("test2"), 0644)
 
 	config := Config{RelativePaths: true}
-	files, err := findFilesWithConfig(dir, config)
+	files, err := scanPaths(dir, config)
 	if err != nil {
-		t.Fatalf("findFilesWithConfig failed: %v", err)
+		t.Fatalf("scanPaths failed: %v", err)
 	}
 
 	// All paths should be relative
@@ -277,9 +310,9 @@ func TestFullPaths(t *testing.T) {
 	os.WriteFile(file2, []byte("test2"), 0644)
 
 	config := Config{RelativePaths: false}
-	files, err := findFilesWithConfig(dir, config)
+	files, err := scanPaths(dir, config)
 	if err != nil {
-		t.Fatalf("findFilesWithConfig failed: %v", err)
+		t.Fatalf("scanPaths failed: %v", err)
 	}
 
 	// All paths should be absolute
@@ -296,9 +329,9 @@ func TestDefaultBehaviorIsRelative(t *testing.T) {
 	os.WriteFile(file1, []byte("test1"), 0644)
 
 	// Default config should use relative paths
-	files, err := findFiles(dir)
+	files, err := scanPaths(dir, Config{RelativePaths: true})
 	if err != nil {
-		t.Fatalf("findFiles failed: %v", err)
+		t.Fatalf("scanPaths failed: %v", err)
 	}
 
 	// Should have relative paths by default
@@ -308,3 +341,318 @@ func TestDefaultBehaviorIsRelative(t *testing.T) {
 		}
 	}
 }
+
+func TestFindEntriesWithAttributes(t *testing.T) {
+	dir := t.TempDir()
+	file1 := filepath.Join(dir, "file1.txt")
+	os.WriteFile(file1, []byte("hello"), 0640)
+
+	config := Config{RelativePaths: true, Attributes: []string{AttrSize, AttrMtime, AttrMode, AttrType}}
+	entries, err := scanEntries(dir, config)
+	if err != nil {
+		t.Fatalf("scanEntries failed: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+
+	info, _ := os.Stat(file1)
+	entry := entries[0]
+	if entry.Path != "file1.txt" {
+		t.Errorf("Expected path file1.txt, got %s", entry.Path)
+	}
+	if entry.Size != 5 {
+		t.Errorf("Expected size 5, got %d", entry.Size)
+	}
+	if !entry.ModTime.Equal(info.ModTime()) {
+		t.Errorf("Expected mtime %v, got %v", info.ModTime(), entry.ModTime)
+	}
+	if entry.Mode != info.Mode().Perm() {
+		t.Errorf("Expected mode %v, got %v", info.Mode().Perm(), entry.Mode)
+	}
+	if entry.Type != "f" {
+		t.Errorf("Expected type f, got %s", entry.Type)
+	}
+}
+
+func TestScanDirectoryStreamsEntries(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 10; i++ {
+		os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d.txt", i)), []byte("x"), 0644)
+	}
+
+	for _, walkers := range []int{1, 4} {
+		t.Run(fmt.Sprintf("walkers=%d", walkers), func(t *testing.T) {
+			errStop := errors.New("stop")
+			seen := 0
+			err := scanDirectory(dir, Config{RelativePaths: true, Walkers: walkers}, func(FileEntry) error {
+				seen++
+				if seen == 3 {
+					return errStop
+				}
+				return nil
+			})
+			if !errors.Is(err, errStop) {
+				t.Errorf("Expected the emit error to stop the scan, got %v", err)
+			}
+			if seen != 3 {
+				t.Errorf("Expected the scan to stop after 3 entries, got %d", seen)
+			}
+		})
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    int64
+		expectError bool
+	}{
+		{"0", 0, false},
+		{"512", 512, false},
+		{"64K", 64 << 10, false},
+		{"256m", 256 << 20, false},
+		{"2G", 2 << 30, false},
+		{"1GiB", 1 << 30, false},
+		{"10MB", 10 << 20, false},
+		{"", 0, true},
+		{"-1", 0, true},
+		{"1.5G", 0, true},
+		{"12X", 0, true},
+		{"99999999999T", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.input)
+		if tt.expectError {
+			if err == nil {
+				t.Errorf("parseByteSize(%q): expected error, got %d", tt.input, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.expected {
+			t.Errorf("parseByteSize(%q) = %d, %v; expected %d", tt.input, got, err, tt.expected)
+		}
+	}
+}
+
+func TestFindFilesWithDirs(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "empty"), 0755)
+	os.MkdirAll(filepath.Join(dir, "src", "pkg"), 0755)
+	os.MkdirAll(filepath.Join(dir, ".git"), 0755)
+	os.WriteFile(filepath.Join(dir, "src", "pkg", "main.go"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(dir, "readme.txt"), []byte("x"), 0644)
+
+	tests := []struct {
+		name     string
+		config   Config
+		expected []string
+	}{
+		{
+			name:     "without dirs",
+			config:   Config{},
+			expected: []string{"readme.txt", "src/pkg/main.go"},
+		},
+		{
+			name:     "with dirs",
+			config:   Config{Dirs: true},
+			expected: []string{"empty/", "readme.txt", "src/", "src/pkg/", "src/pkg/main.go"},
+		},
+		{
+			name:     "hidden dirs",
+			config:   Config{Dirs: true, IncludeHidden: true},
+			expected: []string{".git/", "empty/", "readme.txt", "src/", "src/pkg/", "src/pkg/main.go"},
+		},
+		{
+			name:     "excluded and included",
+			config:   Config{Dirs: true, ExcludePatterns: []string{"empty/"}, IncludePatterns: []string{"src/"}},
+			expected: []string{"src/", "src/pkg/", "src/pkg/main.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.RelativePaths = true
+			tt.config.SortOutput = true
+			files, err := scanPaths(dir, tt.config)
+			if err != nil {
+				t.Fatalf("scanPaths failed: %v", err)
+			}
+			for i := range files {
+				files[i] = filepath.ToSlash(files[i])
+			}
+			if strings.Join(files, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got %v", tt.expected, files)
+			}
+		})
+	}
+}
+
+func TestParseRootArg(t *testing.T) {
+	tests := []struct {
+		arg      string
+		expected labeledRoot
+		wantErr  bool
+	}{
+		{"/opt/app", labeledRoot{Path: "/opt/app"}, false},
+		{"app=/opt/app", labeledRoot{Label: "app", Path: "/opt/app"}, false},
+		{"app=dir=with=equals", labeledRoot{Label: "app", Path: "dir=with=equals"}, false},
+		{"./a=b", labeledRoot{Path: "./a=b"}, false},
+		{"/srv/x=y", labeledRoot{Path: "/srv/x=y"}, false},
+		{"=/opt/app", labeledRoot{Path: "=/opt/app"}, false},
+		{"app=", labeledRoot{}, true},
+		{"..=/opt/app", labeledRoot{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			root, err := parseRootArg(tt.arg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if root != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, root)
+			}
+			if !tt.wantErr && root.String() != tt.arg {
+				t.Errorf("String() should give back %q, got %q", tt.arg, root.String())
+			}
+		})
+	}
+}
+
+func TestResolveRoots(t *testing.T) {
+	base := t.TempDir()
+	for _, name := range []string{"etc", "app", filepath.Join("other", "app")} {
+		os.MkdirAll(filepath.Join(base, name), 0755)
+	}
+	etc := filepath.Join(base, "etc")
+	app := filepath.Join(base, "app")
+	otherApp := filepath.Join(base, "other", "app")
+
+	// A single root keeps relative paths unprefixed
+	roots, err := resolveRoots([]labeledRoot{{Path: etc}})
+	if err != nil || roots[0] != (labeledRoot{Path: etc}) {
+		t.Errorf("Unexpected single root %+v, %v", roots, err)
+	}
+
+	// Several roots default to their base name
+	roots, err = resolveRoots([]labeledRoot{{Path: etc}, {Label: "svc", Path: app}})
+	if err != nil {
+		t.Fatalf("resolveRoots failed: %v", err)
+	}
+	expected := []labeledRoot{{Label: "etc", Path: etc}, {Label: "svc", Path: app}}
+	if fmt.Sprint(roots) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, roots)
+	}
+
+	if _, err := resolveRoots([]labeledRoot{{Path: app}, {Path: otherApp}}); err == nil || !strings.Contains(err.Error(), "same label") {
+		t.Errorf("Expected duplicate label error, got %v", err)
+	}
+	if _, err := resolveRoots([]labeledRoot{{Path: etc}, {Path: filepath.Join(base, "missing")}}); err == nil {
+		t.Error("Expected error for missing root")
+	}
+}
+
+func TestScanDirectories(t *testing.T) {
+	base := t.TempDir()
+	for _, name := range []string{"etc/hosts", "etc/conf.d/app.conf", "app/bin/server", "data/db"} {
+		path := filepath.Join(base, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(name), 0644)
+	}
+
+	roots := []labeledRoot{
+		{Label: "etc", Path: filepath.Join(base, "etc")},
+		{Label: "svc", Path: filepath.Join(base, "app")},
+		{Label: "var", Path: filepath.Join(base, "data")},
+	}
+	var paths []string
+	config := Config{RelativePaths: true, ExcludePatterns: []string{"/conf.d"}, HashAlgorithm: "md5"}
+	err := scanDirectories(roots, config, func(entry FileEntry) error {
+		if entry.Hash == "" {
+			t.Errorf("%s was not hashed", entry.Path)
+		}
+		paths = append(paths, filepath.ToSlash(entry.Path))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("scanDirectories failed: %v", err)
+	}
+
+	// Patterns are anchored at each root; labels prefix the relative paths
+	sort.Strings(paths)
+	expected := "etc/hosts,svc/bin/server,var/db"
+	if strings.Join(paths, ",") != expected {
+		t.Errorf("Expected %s, got %s", expected, strings.Join(paths, ","))
+	}
+
+	// A failing consumer stops every scan and its error is returned as is
+	errFull := errors.New("disk full")
+	err = scanDirectories(roots, config, func(FileEntry) error { return errFull })
+	if err != errFull {
+		t.Errorf("Expected %v, got %v", errFull, err)
+	}
+}
+
+func TestScanDirectoriesCountsAllRoots(t *testing.T) {
+	base := t.TempDir()
+	var roots []labeledRoot
+	for _, label := range []string{"a", "b"} {
+		dir := filepath.Join(base, label)
+		os.Mkdir(dir, 0755)
+		for i := 0; i < 600; i++ {
+			os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%03d", i)), []byte(label), 0644)
+		}
+		roots = append(roots, labeledRoot{Label: label, Path: dir})
+	}
+
+	stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatalf("CreateTemp failed: %v", err)
+	}
+	defer stderr.Close()
+	saved := os.Stderr
+	os.Stderr = stderr
+	defer func() { os.Stderr = saved }()
+
+	count := 0
+	err = scanDirectories(roots, Config{RelativePaths: true, HashAlgorithm: "md5", Jobs: 2}, func(entry FileEntry) error {
+		if entry.Hash == "" {
+			t.Errorf("%s was not hashed", entry.Path)
+		}
+		count++
+		return nil
+	})
+	os.Stderr = saved
+	if err != nil {
+		t.Fatalf("scanDirectories failed: %v", err)
+	}
+	if count != 1200 {
+		t.Errorf("Expected 1200 entries, got %d", count)
+	}
+
+	// Neither root reaches 1000 files, but both together do
+	progress, _ := os.ReadFile(stderr.Name())
+	if string(progress) != "Found 1000 files...\r" {
+		t.Errorf("Expected one progress line for all roots, got %q", progress)
+	}
+}
+
+func TestFormatByteSize(t *testing.T) {
+	tests := []struct {
+		input    int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 30, "5.0 GiB"},
+		{3 << 50, "3.0 PiB"},
+	}
+	for _, tt := range tests {
+		if got := formatByteSize(tt.input); got != tt.expected {
+			t.Errorf("formatByteSize(%d) = %q; expected %q", tt.input, got, tt.expected)
+		}
+	}
+}
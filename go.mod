@@ -2,7 +2,13 @@ module file-inventory
 
 go 1.24.7
 
-require github.com/spf13/cobra v1.10.1
+require (
+	github.com/klauspost/compress v1.19.0
+	github.com/olekukonko/tablewriter v1.0.9
+	github.com/spf13/cobra v1.10.1
+	golang.org/x/crypto v0.45.0
+	golang.org/x/sys v0.38.0
+)
 
 require (
 	github.com/fatih/color v1.15.0 // indirect
@@ -12,8 +18,6 @@ require (
 	github.com/mattn/go-runewidth v0.0.16 // indirect
 	github.com/olekukonko/errors v1.1.0 // indirect
 	github.com/olekukonko/ll v0.0.9 // indirect
-	github.com/olekukonko/tablewriter v1.0.9 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
 	github.com/spf13/pflag v1.0.9 // indirect
-	golang.org/x/sys v0.12.0 // indirect
 )
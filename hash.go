@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// hashAlgorithms maps supported --hash values to their constructors
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+	"blake2b": func() hash.Hash {
+		h, _ := blake2b.New256(nil) // only fails for oversized keys
+		return h
+	},
+}
+
+func supportedHashAlgorithms() []string {
+	var names []string
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateHashAlgorithm checks that algo is empty or a supported algorithm
+func validateHashAlgorithm(algo string) error {
+	if algo == "" {
+		return nil
+	}
+	if _, ok := hashAlgorithms[algo]; !ok {
+		return fmt.Errorf("unsupported hash algorithm %q (supported: %s)", algo, strings.Join(supportedHashAlgorithms(), ","))
+	}
+	return nil
+}
+
+// hashFile returns the digest of the file at path as "algo:hex"
+func hashFile(path, algo string) (string, error) {
+	newHash, ok := hashAlgorithms[algo]
+	if !ok {
+		return "", fmt.Errorf("unsupported hash algorithm %q", algo)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return algo + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashAlgorithmOf returns the algorithm prefix of a digest produced by hashFile
+func hashAlgorithmOf(digest string) string {
+	algo, _, found := strings.Cut(digest, ":")
+	if !found {
+		return ""
+	}
+	return algo
+}
+
+// isEmptyDigest reports whether digest is the digest of empty content
+func isEmptyDigest(digest string) bool {
+	newHash, ok := hashAlgorithms[hashAlgorithmOf(digest)]
+	if !ok {
+		return false
+	}
+	return digest == hashAlgorithmOf(digest)+":"+hex.EncodeToString(newHash().Sum(nil))
+}
+
+// defaultJobs returns the worker count to use when jobs is not positive
+func defaultJobs(jobs int) int {
+	if jobs > 0 {
+		return jobs
+	}
+	return runtime.NumCPU()
+}
+
+// hashPipeline hashes entries on a bounded pool of workers while passing
+// them on in the order they arrived. At most a few entries per worker are in
+// flight, so memory use does not depend on the number of files.
+type hashPipeline struct {
+	algo    string
+	cache   *digestCache // digests to reuse, may be nil
+	next    func(FileEntry) error
+	work    chan hashJob
+	order   chan chan FileEntry // results in arrival order
+	workers sync.WaitGroup
+	done    chan error
+	failed  atomic.Bool
+}
+
+// hashJob is an entry waiting for its digest
+type hashJob struct {
+	entry  FileEntry
+	result chan FileEntry
+}
+
+// newHashPipeline starts a pipeline that hashes regular files with algo and
+// forwards every entry to next. Digests found in cache, if not nil, are used
+// instead of reading the file. Call close once all entries were added.
+func newHashPipeline(algo string, jobs int, cache *digestCache, next func(FileEntry) error) *hashPipeline {
+	jobs = defaultJobs(jobs)
+	p := &hashPipeline{
+		algo:  algo,
+		cache: cache,
+		next:  next,
+		work:  make(chan hashJob),
+		order: make(chan chan FileEntry, 4*jobs),
+		done:  make(chan error, 1),
+	}
+
+	for w := 0; w < jobs; w++ {
+		p.workers.Add(1)
+		go func() {
+			defer p.workers.Done()
+			for job := range p.work {
+				job.result <- p.hash(job.entry)
+			}
+		}()
+	}
+
+	go func() {
+		var err error
+		for result := range p.order {
+			entry := <-result
+			if err == nil {
+				// Keep draining after a failure so add never blocks
+				if err = p.next(entry); err != nil {
+					p.failed.Store(true)
+				}
+			}
+		}
+		p.done <- err
+	}()
+
+	return p
+}
+
+func (p *hashPipeline) hash(entry FileEntry) FileEntry {
+	if p.cache != nil {
+		if digest, ok := p.cache.lookup(entry); ok {
+			entry.Hash = digest
+			return entry
+		}
+	}
+	return hashEntry(entry, p.algo)
+}
+
+// hashEntry fills in the digest of a regular file. Files that cannot be read
+// are reported and left unhashed.
+func hashEntry(entry FileEntry, algo string) FileEntry {
+	digest, err := hashFile(entry.srcPath, algo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot hash %q: %v\n", entry.srcPath, err)
+		return entry
+	}
+	entry.Hash = digest
+	return entry
+}
+
+// add queues entry, blocking while the pipeline is full. It returns
+// errHashPipelineFailed once next has failed; close reports the cause.
+func (p *hashPipeline) add(entry FileEntry) error {
+	if p.failed.Load() {
+		return errHashPipelineFailed
+	}
+
+	result := make(chan FileEntry, 1)
+	p.order <- result
+	if entry.Type == "f" {
+		p.work <- hashJob{entry: entry, result: result}
+	} else {
+		result <- entry
+	}
+	return nil
+}
+
+var errHashPipelineFailed = errors.New("hash pipeline stopped")
+
+// close waits for all queued entries to be forwarded and returns the first
+// error returned by next
+func (p *hashPipeline) close() error {
+	close(p.work)
+	p.workers.Wait()
+	close(p.order)
+	return <-p.done
+}
+
+// reuseColumns are the columns a previous inventory needs for create --reuse
+var reuseColumns = []string{AttrSize, AttrMtime, AttrInode, AttrHash}
+
+// digestCache holds the digests of a previous inventory for create --reuse.
+// A file keeps its previous digest when its size, mtime and inode all match
+// its previous entry, so unchanged files are not read again. It is safe for
+// concurrent lookups.
+type digestCache struct {
+	entries map[string]FileEntry // by path, only entries with a usable digest
+
+	reused     atomic.Int64
+	recomputed atomic.Int64
+}
+
+func newDigestCache() *digestCache {
+	return &digestCache{entries: make(map[string]FileEntry)}
+}
+
+// loadDigestCache reads the digests computed with algo from a previous
+// inventory. The inventory must record every column in reuseColumns.
+func loadDigestCache(filename, algo string) (*digestCache, error) {
+	reader, err := openInventory(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	for _, col := range reuseColumns {
+		if !containsString(reader.Columns, col) {
+			return nil, fmt.Errorf("%s has no %s column; create it with --attrs size,mtime,inode and --hash", filename, col)
+		}
+	}
+
+	cache := newDigestCache()
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return cache, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", filename, err)
+		}
+		// Digests from another algorithm cannot be carried over
+		if entry.Hash != "" && hashAlgorithmOf(entry.Hash) == algo {
+			cache.entries[entry.Path] = FileEntry{Size: entry.Size, ModTime: entry.ModTime, Inode: entry.Inode, Hash: entry.Hash}
+		}
+	}
+}
+
+// lookup returns the previous digest of entry if the file is unchanged,
+// counting the entry as reused or recomputed
+func (c *digestCache) lookup(entry FileEntry) (string, bool) {
+	prev, ok := c.entries[entry.Path]
+	if ok && prev.Size == entry.Size && prev.ModTime.Equal(entry.ModTime) && prev.Inode == entry.Inode {
+		c.reused.Add(1)
+		return prev.Hash, true
+	}
+	c.recomputed.Add(1)
+	return "", false
+}
+
+// Reused returns the number of digests taken from the previous inventory
+func (c *digestCache) Reused() int64 {
+	return c.reused.Load()
+}
+
+// Recomputed returns the number of files that had to be hashed
+func (c *digestCache) Recomputed() int64 {
+	return c.recomputed.Load()
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashFileKnownDigests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hello.txt")
+	os.WriteFile(path, []byte("hello"), 0644)
+
+	tests := []struct {
+		algo     string
+		expected string
+	}{
+		{"sha256", "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{"sha1", "sha1:aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"},
+		{"md5", "md5:5d41402abc4b2a76b9719d911017c592"},
+		{"blake2b", "blake2b:324dcf027dd4a30a932c441f365a25e86b173defa4b8e58948253471b81b72cf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algo, func(t *testing.T) {
+			digest, err := hashFile(path, tt.algo)
+			if err != nil {
+				t.Fatalf("hashFile failed: %v", err)
+			}
+			if digest != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, digest)
+			}
+			if hashAlgorithmOf(digest) != tt.algo {
+				t.Errorf("Expected algorithm %s, got %s", tt.algo, hashAlgorithmOf(digest))
+			}
+		})
+	}
+}
+
+func TestIsEmptyDigest(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "empty")
+	os.WriteFile(empty, nil, 0644)
+	for _, algo := range supportedHashAlgorithms() {
+		digest, err := hashFile(empty, algo)
+		if err != nil {
+			t.Fatalf("hashFile failed: %v", err)
+		}
+		if !isEmptyDigest(digest) {
+			t.Errorf("Expected %s to be the empty digest", digest)
+		}
+	}
+	for _, digest := range []string{"", "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", "crc32:00000000"} {
+		if isEmptyDigest(digest) {
+			t.Errorf("Expected %q not to be the empty digest", digest)
+		}
+	}
+}
+
+func TestValidateHashAlgorithm(t *testing.T) {
+	if err := validateHashAlgorithm(""); err != nil {
+		t.Errorf("Empty algorithm should be accepted: %v", err)
+	}
+	if err := validateHashAlgorithm("sha256"); err != nil {
+		t.Errorf("sha256 should be accepted: %v", err)
+	}
+	if err := validateHashAlgorithm("crc32"); err == nil {
+		t.Error("Expected error for unsupported algorithm")
+	}
+}
+
+func TestFindEntriesWithHashing(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 20; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("file%02d.txt", i))
+		os.WriteFile(name, []byte(fmt.Sprintf("content %d", i)), 0644)
+	}
+
+	config := Config{RelativePaths: true, SortOutput: true, HashAlgorithm: "sha256", Jobs: 4}
+	entries, err := scanEntries(dir, config)
+	if err != nil {
+		t.Fatalf("scanEntries failed: %v", err)
+	}
+	if len(entries) != 20 {
+		t.Fatalf("Expected 20 entries, got %d", len(entries))
+	}
+
+	for _, entry := range entries {
+		expected, err := hashFile(filepath.Join(dir, entry.Path), "sha256")
+		if err != nil {
+			t.Fatalf("hashFile failed: %v", err)
+		}
+		if entry.Hash != expected {
+			t.Errorf("%s: expected %s, got %s", entry.Path, expected, entry.Hash)
+		}
+	}
+}
+
+func TestRunCreateCommandWithHash(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0644)
+	output := filepath.Join(t.TempDir(), "inventory.txt")
+
+	config := Config{RelativePaths: true, HashAlgorithm: "md5"}
+	if err := runCreateCommand(dir, output, config); err != nil {
+		t.Fatalf("runCreateCommand failed: %v", err)
+	}
+
+	inv, err := readInventory(output)
+	if err != nil {
+		t.Fatalf("readInventory failed: %v", err)
+	}
+	if !inv.HasColumn(AttrHash) {
+		t.Fatalf("Expected hash column, got %v", inv.Columns)
+	}
+	if len(inv.Entries) != 1 || !strings.HasPrefix(inv.Entries[0].Hash, "md5:5d41402a") {
+		t.Errorf("Unexpected entries: %+v", inv.Entries)
+	}
+}
+
+func TestHashPipelinePreservesOrder(t *testing.T) {
+	dir := t.TempDir()
+	var input []FileEntry
+	for i := 0; i < 200; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("file%03d.txt", i))
+		os.WriteFile(path, []byte(strings.Repeat("x", i)), 0644)
+		entry := FileEntry{Path: filepath.Base(path), Type: "f", srcPath: path}
+		if i%10 == 0 {
+			entry.Type = "l" // only regular files are hashed
+		}
+		input = append(input, entry)
+	}
+
+	var output []FileEntry
+	p := newHashPipeline("sha256", 8, nil, func(entry FileEntry) error {
+		output = append(output, entry)
+		return nil
+	})
+	for _, entry := range input {
+		if err := p.add(entry); err != nil {
+			t.Fatalf("add failed: %v", err)
+		}
+	}
+	if err := p.close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	if len(output) != len(input) {
+		t.Fatalf("Expected %d entries, got %d", len(input), len(output))
+	}
+	for i, entry := range output {
+		if entry.Path != input[i].Path {
+			t.Fatalf("Entry %d: expected %s, got %s", i, input[i].Path, entry.Path)
+		}
+		if (entry.Hash != "") != (entry.Type == "f") {
+			t.Errorf("Entry %s of type %s has hash %q", entry.Path, entry.Type, entry.Hash)
+		}
+	}
+}
+
+func TestHashPipelineStopsOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	os.WriteFile(path, []byte("a"), 0644)
+
+	errFull := fmt.Errorf("disk full")
+	calls := 0
+	p := newHashPipeline("md5", 2, nil, func(FileEntry) error {
+		calls++
+		return errFull
+	})
+
+	// add may accept a few more entries before it notices the failure
+	for i := 0; i < 100; i++ {
+		if p.add(FileEntry{Path: "a.txt", Type: "f", srcPath: path}) != nil {
+			break
+		}
+	}
+	if err := p.close(); err != errFull {
+		t.Errorf("Expected %v, got %v", errFull, err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected next to be called once, got %d", calls)
+	}
+}
+
+func TestDigestCache(t *testing.T) {
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	columns := []string{AttrSize, AttrMtime, AttrInode, AttrHash}
+	previous := filepath.Join(t.TempDir(), "previous.txt")
+	writeInventory(previous, formatText, columns, []FileEntry{
+		{Path: "a.txt", Size: 5, ModTime: mtime, Inode: 42, Hash: "sha256:aaaa"},
+		{Path: "b.txt", Size: 5, ModTime: mtime, Inode: 43, Hash: "md5:bbbb"},
+		{Path: "link", Size: 5, ModTime: mtime, Inode: 44},
+	})
+
+	cache, err := loadDigestCache(previous, "sha256")
+	if err != nil {
+		t.Fatalf("loadDigestCache failed: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		entry FileEntry
+		hash  string
+	}{
+		{"unchanged", FileEntry{Path: "a.txt", Size: 5, ModTime: mtime, Inode: 42}, "sha256:aaaa"},
+		{"size changed", FileEntry{Path: "a.txt", Size: 6, ModTime: mtime, Inode: 42}, ""},
+		{"mtime changed", FileEntry{Path: "a.txt", Size: 5, ModTime: mtime.Add(time.Second), Inode: 42}, ""},
+		{"replaced file", FileEntry{Path: "a.txt", Size: 5, ModTime: mtime, Inode: 99}, ""},
+		{"other algorithm", FileEntry{Path: "b.txt", Size: 5, ModTime: mtime, Inode: 43}, ""},
+		{"not hashed before", FileEntry{Path: "link", Size: 5, ModTime: mtime, Inode: 44}, ""},
+		{"new file", FileEntry{Path: "c.txt", Size: 5, ModTime: mtime, Inode: 45}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, ok := cache.lookup(tt.entry)
+			if hash != tt.hash || ok != (tt.hash != "") {
+				t.Errorf("Expected %q, got %q (%v)", tt.hash, hash, ok)
+			}
+		})
+	}
+	if cache.Reused() != 1 || cache.Recomputed() != int64(len(tests)-1) {
+		t.Errorf("Expected 1 reused and %d recomputed, got %d and %d", len(tests)-1, cache.Reused(), cache.Recomputed())
+	}
+
+	// Inventories without the columns needed to trust a digest are rejected
+	writeInventory(previous, formatText, []string{AttrSize, AttrHash}, nil)
+	if _, err := loadDigestCache(previous, "sha256"); err == nil || !strings.Contains(err.Error(), "mtime") {
+		t.Errorf("Expected missing column error, got %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version is the tool version recorded in inventory headers. Release builds
+// set it with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// Header lines of text inventories, written between the magic line and the
+// column list:
+//
+//	#file-inventory 1
+//	#root /srv/data
+//	#root app=/opt/app
+//	#host fileserver01
+//	#created 2024-05-01T02:00:00Z
+//	#version v1.4.0
+//	#config {"relative_paths":true,"include_hidden":false,...}
+//	#columns path size mtime
+//
+// JSON inventories carry the same information as a leading {"header": {...}}
+// element. There is a #root line for every root scanned, labeled as given
+// on the command line.
+const (
+	headerRoot    = "#root"
+	headerHost    = "#host"
+	headerCreated = "#created"
+	headerVersion = "#version"
+	headerConfig  = "#config"
+)
+
+// inventoryHeader describes where, when and how an inventory was made
+type inventoryHeader struct {
+	Roots   []labeledRoot `json:"roots"`
+	Host    string        `json:"host"`
+	Created time.Time     `json:"created"`
+	Version string        `json:"version"`
+	Config  scanSettings  `json:"config"`
+}
+
+// scanSettings is the part of a Config that decides which entries an
+// inventory lists and what it records about them
+type scanSettings struct {
+	RelativePaths    bool       `json:"relative_paths"`
+	IncludeHidden    bool       `json:"include_hidden"`
+	ExcludePatterns  []string   `json:"exclude,omitempty"`
+	IncludePatterns  []string   `json:"include,omitempty"`
+	RespectGitignore bool       `json:"respect_gitignore"`
+	Attributes       []string   `json:"attributes,omitempty"`
+	HashAlgorithm    string     `json:"hash,omitempty"`
+	Symlinks         string     `json:"symlinks,omitempty"`
+	Dirs             bool       `json:"dirs"`
+	Types            []string   `json:"types,omitempty"`
+	MinSize          int64      `json:"min_size,omitempty"`
+	MaxSize          *int64     `json:"max_size,omitempty"`
+	NewerThan        *time.Time `json:"newer,omitempty"`
+	OlderThan        *time.Time `json:"older,omitempty"`
+	TimeField        string     `json:"time_field,omitempty"`
+	Sorted           bool       `json:"sorted"`
+}
+
+func newScanSettings(config Config) scanSettings {
+	s := scanSettings{
+		RelativePaths:    config.RelativePaths,
+		IncludeHidden:    config.IncludeHidden,
+		ExcludePatterns:  config.ExcludePatterns,
+		IncludePatterns:  config.IncludePatterns,
+		RespectGitignore: config.RespectGitignore,
+		Attributes:       config.Attributes,
+		HashAlgorithm:    config.HashAlgorithm,
+		Symlinks:         config.Symlinks,
+		Dirs:             config.Dirs,
+		Types:            config.Types,
+		MinSize:          config.MinSize,
+		TimeField:        config.TimeField,
+		Sorted:           config.SortOutput,
+	}
+	if config.HasMaxSize {
+		maxSize := config.MaxSize
+		s.MaxSize = &maxSize
+	}
+	if !config.NewerThan.IsZero() {
+		t := config.NewerThan.UTC()
+		s.NewerThan = &t
+	}
+	if !config.OlderThan.IsZero() {
+		t := config.OlderThan.UTC()
+		s.OlderThan = &t
+	}
+	return s
+}
+
+// config returns the Config that repeats the scan these settings describe
+func (s scanSettings) config() Config {
+	config := Config{
+		SortOutput:       s.Sorted,
+		RelativePaths:    s.RelativePaths,
+		IncludeHidden:    s.IncludeHidden,
+		ExcludePatterns:  s.ExcludePatterns,
+		IncludePatterns:  s.IncludePatterns,
+		RespectGitignore: s.RespectGitignore,
+		Attributes:       s.Attributes,
+		HashAlgorithm:    s.HashAlgorithm,
+		Symlinks:         s.Symlinks,
+		Dirs:             s.Dirs,
+		Types:            s.Types,
+		MinSize:          s.MinSize,
+		TimeField:        s.TimeField,
+	}
+	if s.MaxSize != nil {
+		config.MaxSize = *s.MaxSize
+		config.HasMaxSize = true
+	}
+	if s.NewerThan != nil {
+		config.NewerThan = *s.NewerThan
+	}
+	if s.OlderThan != nil {
+		config.OlderThan = *s.OlderThan
+	}
+	return config
+}
+
+// newInventoryHeader describes a scan of roots with config, made now
+func newInventoryHeader(roots []labeledRoot, config Config) *inventoryHeader {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &inventoryHeader{
+		Roots:   roots,
+		Host:    host,
+		Created: time.Now().UTC().Truncate(time.Second),
+		Version: version,
+		Config:  newScanSettings(config),
+	}
+}
+
+// String summarizes the header on one line
+func (h *inventoryHeader) String() string {
+	roots := make([]string, len(h.Roots))
+	for i, root := range h.Roots {
+		roots[i] = root.String()
+	}
+	return fmt.Sprintf("%s on %s, created %s by file-inventory %s",
+		strings.Join(roots, ", "), h.Host, h.Created.UTC().Format(time.RFC3339), h.Version)
+}
+
+// textLines returns the header lines of a text inventory
+func (h *inventoryHeader) textLines() ([]string, error) {
+	config, err := json.Marshal(h.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	var lines []string
+	for _, root := range h.Roots {
+		lines = append(lines, headerRoot+" "+escapeField(root.String()))
+	}
+	return append(lines,
+		headerHost+" "+escapeField(h.Host),
+		headerCreated+" "+h.Created.UTC().Format(time.RFC3339Nano),
+		headerVersion+" "+escapeField(h.Version),
+		headerConfig+" "+string(config),
+	), nil
+}
+
+// parseTextLine fills in the header from one text header line. It reports
+// false for lines that are not part of the header.
+func (h *inventoryHeader) parseTextLine(line string) (bool, error) {
+	key, value, _ := strings.Cut(line, " ")
+	var err error
+	switch key {
+	case headerRoot:
+		var arg string
+		if arg, err = unescapeField(value); err == nil {
+			var root labeledRoot
+			if root, err = parseRootArg(arg); err == nil {
+				h.Roots = append(h.Roots, root)
+			}
+		}
+	case headerHost:
+		h.Host, err = unescapeField(value)
+	case headerVersion:
+		h.Version, err = unescapeField(value)
+	case headerCreated:
+		if h.Created, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			err = fmt.Errorf("invalid creation time %q", value)
+		}
+	case headerConfig:
+		if err = json.Unmarshal([]byte(value), &h.Config); err != nil {
+			err = fmt.Errorf("invalid config: %w", err)
+		}
+	default:
+		return false, nil
+	}
+	return true, err
+}
+
+// incompatibleSettings lists the settings that differ between two inventories
+// in ways that make them list different entries, so that a diff between
+// them shows more than what changed on disk. Recorded attributes may differ,
+// as only the common ones are compared.
+func incompatibleSettings(a, b scanSettings) []string {
+	var diffs []string
+	check := func(name string, differ bool, va, vb any) {
+		if differ {
+			diffs = append(diffs, fmt.Sprintf("%s %v vs %v", name, va, vb))
+		}
+	}
+
+	check("relative paths", a.RelativePaths != b.RelativePaths, a.RelativePaths, b.RelativePaths)
+	check("hidden files", a.IncludeHidden != b.IncludeHidden, a.IncludeHidden, b.IncludeHidden)
+	check("exclude", !slices.Equal(a.ExcludePatterns, b.ExcludePatterns), a.ExcludePatterns, b.ExcludePatterns)
+	check("include", !slices.Equal(a.IncludePatterns, b.IncludePatterns), a.IncludePatterns, b.IncludePatterns)
+	check("respect gitignore", a.RespectGitignore != b.RespectGitignore, a.RespectGitignore, b.RespectGitignore)
+	check("hash", a.HashAlgorithm != "" && b.HashAlgorithm != "" && a.HashAlgorithm != b.HashAlgorithm, a.HashAlgorithm, b.HashAlgorithm)
+	check("symlinks", a.Symlinks != b.Symlinks, settingOrDefault(a.Symlinks), settingOrDefault(b.Symlinks))
+	check("dirs", a.Dirs != b.Dirs, a.Dirs, b.Dirs)
+	check("types", !slices.Equal(a.Types, b.Types), a.Types, b.Types)
+	check("min size", a.MinSize != b.MinSize, a.MinSize, b.MinSize)
+	check("max size", !equalSizes(a.MaxSize, b.MaxSize), formatSettingSize(a.MaxSize), formatSettingSize(b.MaxSize))
+	check("newer", !equalTimes(a.NewerThan, b.NewerThan), formatSettingTime(a.NewerThan), formatSettingTime(b.NewerThan))
+	check("older", !equalTimes(a.OlderThan, b.OlderThan), formatSettingTime(a.OlderThan), formatSettingTime(b.OlderThan))
+	return diffs
+}
+
+func settingOrDefault(s string) string {
+	if s == "" {
+		return "default"
+	}
+	return s
+}
+
+func equalTimes(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func equalSizes(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func formatSettingSize(n *int64) string {
+	if n == nil {
+		return "none"
+	}
+	return strconv.FormatInt(*n, 10)
+}
+
+func formatSettingTime(t *time.Time) string {
+	if t == nil {
+		return "none"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// warnIncompatible prints a warning for every inventory with a header that
+// was made with settings incompatible with those of the first one
+func warnIncompatible(files []string, headers []*inventoryHeader) {
+	if len(headers) == 0 || headers[0] == nil {
+		return
+	}
+	for i := 1; i < len(headers); i++ {
+		if headers[i] == nil {
+			continue
+		}
+		if diffs := incompatibleSettings(headers[0].Config, headers[i].Config); len(diffs) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: %s and %s were created with different settings: %s\n",
+				files[0], files[i], strings.Join(diffs, "; "))
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sampleHeader() *inventoryHeader {
+	newer := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &inventoryHeader{
+		Roots:   []labeledRoot{{Path: "/srv/data dir"}, {Label: "app", Path: "/opt/app=1"}},
+		Host:    "fileserver01",
+		Created: time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC),
+		Version: "v1.4.0",
+		Config: scanSettings{
+			RelativePaths:   true,
+			ExcludePatterns: []string{"*.tmp", "cache/"},
+			Attributes:      []string{AttrSize, AttrMtime},
+			HashAlgorithm:   "sha256",
+			Symlinks:        symlinksRecord,
+			Types:           []string{"f", "l"},
+			MinSize:         1024,
+			NewerThan:       &newer,
+			Sorted:          true,
+		},
+	}
+}
+
+func TestInventoryHeaderRoundTrip(t *testing.T) {
+	header := sampleHeader()
+	columns := []string{AttrSize, AttrMtime, AttrHash}
+	entries := []FileEntry{
+		{Path: "a.txt", Size: 5, ModTime: time.Date(2024, 5, 1, 1, 0, 0, 0, time.UTC), Hash: "sha256:aaaa"},
+	}
+
+	for _, format := range []string{formatText, formatJSON, formatJSONL} {
+		t.Run(format, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "inventory."+format)
+			if err := writeInventoryWithHeader(file, format, columns, header, entries); err != nil {
+				t.Fatalf("writeInventoryWithHeader failed: %v", err)
+			}
+
+			inv, err := readInventory(file)
+			if err != nil {
+				t.Fatalf("readInventory failed: %v", err)
+			}
+			if !reflect.DeepEqual(inv.Header, header) {
+				t.Errorf("Expected header %+v, got %+v", header, inv.Header)
+			}
+			if len(inv.Entries) != 1 || inv.Entries[0].Hash != "sha256:aaaa" {
+				t.Errorf("Unexpected entries: %+v", inv.Entries)
+			}
+			if !reflect.DeepEqual(inv.Columns, columns) {
+				t.Errorf("Expected columns %v, got %v", columns, inv.Columns)
+			}
+
+			// The streaming reader sees the same header before any entry
+			reader, err := openInventory(file)
+			if err != nil {
+				t.Fatalf("openInventory failed: %v", err)
+			}
+			defer reader.Close()
+			if !reflect.DeepEqual(reader.Header, header) {
+				t.Errorf("Expected streamed header %+v, got %+v", header, reader.Header)
+			}
+		})
+	}
+}
+
+func TestInventoryWithoutHeader(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "inventory.txt")
+	if err := writeInventory(file, formatText, []string{AttrSize}, []FileEntry{{Path: "a.txt", Size: 1}}); err != nil {
+		t.Fatalf("writeInventory failed: %v", err)
+	}
+	inv, err := readInventory(file)
+	if err != nil {
+		t.Fatalf("readInventory failed: %v", err)
+	}
+	if inv.Header != nil {
+		t.Errorf("Expected no header, got %+v", inv.Header)
+	}
+}
+
+func TestScanSettingsConfig(t *testing.T) {
+	config := Config{
+		RelativePaths:   true,
+		IncludeHidden:   true,
+		IncludePatterns: []string{"*.go"},
+		Attributes:      []string{AttrSize},
+		HashAlgorithm:   "md5",
+		Dirs:            true,
+		MaxSize:         100,
+		HasMaxSize:      true,
+		OlderThan:       time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		TimeField:       "ctime",
+		SortOutput:      true,
+	}
+
+	// Settings survive the trip through JSON and back to a Config
+	data, err := json.Marshal(newScanSettings(config))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var settings scanSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if got := settings.config(); !reflect.DeepEqual(got, config) {
+		t.Errorf("Expected %+v, got %+v", config, got)
+	}
+}
+
+func TestIncompatibleSettings(t *testing.T) {
+	base := sampleHeader().Config
+	later := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		change func(s *scanSettings)
+		want   string
+	}{
+		{"identical", func(s *scanSettings) {}, ""},
+		{"other attributes", func(s *scanSettings) { s.Attributes = []string{AttrMode} }, ""},
+		{"unsorted", func(s *scanSettings) { s.Sorted = false }, ""},
+		{"not hashed", func(s *scanSettings) { s.HashAlgorithm = "" }, ""},
+		{"other hash", func(s *scanSettings) { s.HashAlgorithm = "md5" }, "hash sha256 vs md5"},
+		{"absolute paths", func(s *scanSettings) { s.RelativePaths = false }, "relative paths true vs false"},
+		{"exclude", func(s *scanSettings) { s.ExcludePatterns = nil }, "exclude [*.tmp cache/] vs []"},
+		{"symlinks", func(s *scanSettings) { s.Symlinks = "" }, "symlinks record vs default"},
+		{"newer", func(s *scanSettings) { s.NewerThan = &later }, "newer 2024-01-01T00:00:00Z vs 2024-02-01T00:00:00Z"},
+		{"older", func(s *scanSettings) { s.OlderThan = &later }, "older none vs 2024-02-01T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := base
+			tt.change(&other)
+			got := strings.Join(incompatibleSettings(base, other), "; ")
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRunCreateCommandWritesHeader(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0644)
+	output := filepath.Join(t.TempDir(), "inventory.txt")
+
+	config := Config{RelativePaths: true, ExcludePatterns: []string{"*.log"}, Header: true}
+	if err := runCreateCommand(dir, output, config); err != nil {
+		t.Fatalf("runCreateCommand failed: %v", err)
+	}
+
+	inv, err := readInventory(output)
+	if err != nil {
+		t.Fatalf("readInventory failed: %v", err)
+	}
+	if inv.Header == nil {
+		t.Fatal("Expected a header")
+	}
+	if root, _ := filepath.Abs(dir); len(inv.Header.Roots) != 1 || inv.Header.Roots[0] != (labeledRoot{Path: root}) {
+		t.Errorf("Expected root %s, got %v", root, inv.Header.Roots)
+	}
+	if inv.Header.Version != version || inv.Header.Host == "" || inv.Header.Created.IsZero() {
+		t.Errorf("Incomplete header: %+v", inv.Header)
+	}
+	if !reflect.DeepEqual(inv.Header.Config.ExcludePatterns, config.ExcludePatterns) {
+		t.Errorf("Expected exclude patterns %v, got %v", config.ExcludePatterns, inv.Header.Config.ExcludePatterns)
+	}
+	if len(inv.Entries) != 1 || inv.Entries[0].Path != "a.txt" {
+		t.Errorf("Unexpected entries: %+v", inv.Entries)
+	}
+}
+
+func TestShowDiffShowsHeaders(t *testing.T) {
+	dir := t.TempDir()
+	header := sampleHeader()
+	file1 := filepath.Join(dir, "inv1.txt")
+	file2 := filepath.Join(dir, "inv2.txt")
+	writeInventoryWithHeader(file1, formatText, nil, header, []FileEntry{{Path: "a.txt"}})
+	writeInventory(file2, formatText, nil, []FileEntry{{Path: "b.txt"}})
+
+	var buf bytes.Buffer
+	if _, err := showDiffFiles([]string{file1, file2}, DiffOptions{Output: &buf}); err != nil {
+		t.Fatalf("showDiffFiles failed: %v", err)
+	}
+	if want := file1 + ": " + header.String(); !strings.Contains(buf.String(), want) {
+		t.Errorf("Expected %q in output:\n%s", want, buf.String())
+	}
+	if strings.Contains(buf.String(), file2+": ") {
+		t.Errorf("Unexpected header line for %s:\n%s", file2, buf.String())
+	}
+
+	buf.Reset()
+	if _, err := showDiffFiles([]string{file1, file2}, DiffOptions{Format: diffFormatJSON, Output: &buf}); err != nil {
+		t.Fatalf("showDiffFiles failed: %v", err)
+	}
+	var doc jsonDiff
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	if len(doc.Headers) != 2 || doc.Headers[0] == nil || !reflect.DeepEqual(doc.Headers[0].Roots, header.Roots) || doc.Headers[1] != nil {
+		t.Errorf("Unexpected headers: %+v", doc.Headers)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignorePattern is a single compiled gitignore-style pattern. Patterns are
+// matched against slash-separated paths relative to the scan root.
+type ignorePattern struct {
+	negate   bool     // pattern started with '!'
+	dirOnly  bool     // pattern ended with '/'
+	anchored bool     // pattern contained a '/' before its end
+	segments []string // pattern split on '/'
+}
+
+// compileIgnorePattern parses one line of gitignore syntax. It returns false
+// for blank lines and comments.
+func compileIgnorePattern(line string) (ignorePattern, bool) {
+	line = trimTrailingSpaces(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+
+	var p ignorePattern
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		p.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return ignorePattern{}, false
+	}
+
+	// gitignore spells negated character classes [!...], path.Match uses [^...]
+	line = strings.ReplaceAll(line, "[!", "[^")
+
+	for _, seg := range strings.Split(line, "/") {
+		// Consecutive "**" segments are equivalent to a single one
+		if seg == "**" && len(p.segments) > 0 && p.segments[len(p.segments)-1] == "**" {
+			continue
+		}
+		p.segments = append(p.segments, seg)
+	}
+	return p, true
+}
+
+// trimTrailingSpaces drops unescaped trailing spaces, as git does
+func trimTrailingSpaces(line string) string {
+	line = strings.TrimRight(line, "\r")
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// match reports whether the pattern matches relPath, ignoring negation
+func (p ignorePattern) match(relPath string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if !p.anchored {
+		// Patterns without a slash match the name at any depth
+		ok, _ := path.Match(p.segments[0], path.Base(relPath))
+		return ok
+	}
+	return matchSegments(p.segments, strings.Split(relPath, "/"))
+}
+
+// matchSegments matches pattern segments against path segments, where "**"
+// stands for zero or more whole path segments
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			if len(rest) == 0 {
+				// A trailing "/**" matches everything inside, but not the directory itself
+				return len(parts) > 0
+			}
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(rest, parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// patternList is an ordered list of patterns where the last match wins
+type patternList []ignorePattern
+
+// compilePatterns compiles a list of gitignore-style patterns
+func compilePatterns(lines []string) patternList {
+	var list patternList
+	for _, line := range lines {
+		if p, ok := compileIgnorePattern(line); ok {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// lastMatch returns +1 if the last pattern matching relPath is positive,
+// -1 if it is negated and 0 if no pattern matches
+func (l patternList) lastMatch(relPath string, isDir bool) int {
+	for i := len(l) - 1; i >= 0; i-- {
+		if l[i].match(relPath, isDir) {
+			if l[i].negate {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// matches reports whether relPath is selected by the list. As in git, a path
+// inside a selected directory is selected too, and cannot be unselected by a
+// negated pattern for the path itself.
+func (l patternList) matches(relPath string, isDir bool) bool {
+	if len(l) == 0 {
+		return false
+	}
+
+	for i := 0; i < len(relPath); i++ {
+		if relPath[i] == '/' && l.lastMatch(relPath[:i], true) > 0 {
+			return true
+		}
+	}
+	return l.lastMatch(relPath, isDir) > 0
+}
+
+// pathFilter applies the include and exclude patterns from a Config
+type pathFilter struct {
+	include patternList
+	exclude patternList
+}
+
+func newPathFilter(config Config) pathFilter {
+	return pathFilter{
+		include: compilePatterns(config.IncludePatterns),
+		exclude: compilePatterns(config.ExcludePatterns),
+	}
+}
+
+// skipDir reports whether a directory is excluded and need not be walked
+func (f pathFilter) skipDir(relPath string) bool {
+	return f.exclude.matches(relPath, true)
+}
+
+// includeFile reports whether a file passes the include and exclude patterns
+func (f pathFilter) includeFile(relPath string) bool {
+	// If include patterns are specified, file must match at least one
+	if len(f.include) > 0 && !f.include.matches(relPath, false) {
+		return false
+	}
+
+	// If exclude patterns are specified, file must not match any
+	return !f.exclude.matches(relPath, false)
+}
+
+// includeDir reports whether a directory entry passes the include and
+// exclude patterns
+func (f pathFilter) includeDir(relPath string) bool {
+	if len(f.include) > 0 && !f.include.matches(relPath, true) {
+		return false
+	}
+	return !f.exclude.matches(relPath, true)
+}
+
+// Names of per-directory ignore files
+const (
+	inventoryIgnoreFile = ".inventoryignore"
+	gitIgnoreFile       = ".gitignore"
+)
+
+// ignoreRules holds the patterns from the ignore files of one directory and
+// links to the rules of its parent, so that each subtree carries exactly the
+// rules that apply to it. A nil *ignoreRules has no rules.
+type ignoreRules struct {
+	parent   *ignoreRules
+	base     string // directory holding the ignore files, relative to the root ("" for the root)
+	patterns patternList
+}
+
+// ignoreFileNames returns the ignore files to load for config. Later files
+// win, so .inventoryignore can override a .gitignore in the same directory.
+func ignoreFileNames(config Config) []string {
+	if config.RespectGitignore {
+		return []string{gitIgnoreFile, inventoryIgnoreFile}
+	}
+	return []string{inventoryIgnoreFile}
+}
+
+// enterDir loads the ignore files found in absDir and returns the rules in
+// effect inside it. relDir is absDir relative to the scan root, slash
+// separated. The receiver is returned unchanged when absDir has no ignore
+// files.
+func (r *ignoreRules) enterDir(absDir, relDir string, fileNames []string) *ignoreRules {
+	var patterns patternList
+	for _, name := range fileNames {
+		data, err := os.ReadFile(filepath.Join(absDir, name))
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				fmt.Fprintf(os.Stderr, "Warning: cannot read %q: %v\n", filepath.Join(absDir, name), err)
+			}
+			continue
+		}
+		patterns = append(patterns, compilePatterns(strings.Split(string(data), "\n"))...)
+	}
+
+	if len(patterns) == 0 {
+		return r
+	}
+	if relDir == "." {
+		relDir = ""
+	}
+	return &ignoreRules{parent: r, base: relDir, patterns: patterns}
+}
+
+// ignored reports whether relPath is ignored. Rules from deeper directories
+// take precedence over those of their ancestors, as with nested .gitignore
+// files. Only the path itself is checked; callers are expected not to
+// descend into ignored directories.
+func (r *ignoreRules) ignored(relPath string, isDir bool) bool {
+	for rules := r; rules != nil; rules = rules.parent {
+		local := relPath
+		if rules.base != "" {
+			local = strings.TrimPrefix(relPath, rules.base+"/")
+		}
+		if m := rules.patterns.lastMatch(local, isDir); m != 0 {
+			return m > 0
+		}
+	}
+	return false
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIgnorePatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		expect  bool
+	}{
+		// Patterns without a slash match the name at any depth
+		{"*.log", "app.log", false, true},
+		{"*.log", "var/log/app.log", false, true},
+		{"*.log", "app.log.gz", false, false},
+		{"node_modules", "web/node_modules", true, true},
+
+		// Trailing slash only matches directories
+		{"node_modules/", "web/node_modules", true, true},
+		{"node_modules/", "web/node_modules", false, false},
+
+		// Leading or middle slash anchors to the root
+		{"/vendor", "vendor", true, true},
+		{"/vendor", "src/vendor", true, false},
+		{"doc/*.txt", "doc/notes.txt", false, true},
+		{"doc/*.txt", "doc/sub/notes.txt", false, false},
+		{"doc/*.txt", "src/doc/notes.txt", false, false},
+
+		// Double asterisks
+		{"**/logs", "logs", true, true},
+		{"**/logs", "a/b/logs", true, true},
+		{"**/logs/debug.log", "build/logs/debug.log", false, true},
+		{"build/**", "build/out/app", false, true},
+		{"build/**", "build", true, false},
+		{"a/**/b", "a/b", true, true},
+		{"a/**/b", "a/x/y/b", true, true},
+		{"a/**/b", "a/x/y/c", true, false},
+
+		// Character classes, including gitignore's [!...] negation
+		{"file[0-9].txt", "file7.txt", false, true},
+		{"file[!0-9].txt", "file7.txt", false, false},
+		{"file[!0-9].txt", "fileA.txt", false, true},
+
+		// Escapes
+		{`\!important`, "!important", false, true},
+		{`\#hash`, "#hash", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+"~"+tt.path, func(t *testing.T) {
+			p, ok := compileIgnorePattern(tt.pattern)
+			if !ok {
+				t.Fatalf("Pattern %q did not compile", tt.pattern)
+			}
+			if got := p.match(tt.path, tt.isDir); got != tt.expect {
+				t.Errorf("match(%q, %q, dir=%v) = %v, expected %v", tt.pattern, tt.path, tt.isDir, got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestCompileIgnorePatternSkipsBlankAndComments(t *testing.T) {
+	for _, line := range []string{"", "   ", "# comment", "/", "!"} {
+		if _, ok := compileIgnorePattern(line); ok {
+			t.Errorf("Expected %q to be skipped", line)
+		}
+	}
+}
+
+func TestPatternListNegationAndPrecedence(t *testing.T) {
+	list := compilePatterns([]string{"*.log", "!keep.log", "logs/", "!logs/important.log"})
+
+	tests := []struct {
+		path   string
+		expect bool
+	}{
+		{"debug.log", true},
+		{"keep.log", false},
+		{"sub/keep.log", false},
+		{"other.txt", false},
+		// A file inside a matched directory cannot be re-included
+		{"logs/important.log", true},
+		{"logs/data.txt", true},
+	}
+
+	for _, tt := range tests {
+		if got := list.matches(tt.path, false); got != tt.expect {
+			t.Errorf("matches(%q) = %v, expected %v", tt.path, got, tt.expect)
+		}
+	}
+}
+
+func TestFindFilesWithGitignorePatterns(t *testing.T) {
+	setup := []string{
+		"main.go",
+		"build/out/app",
+		"build/out/app.log",
+		"src/build/gen.go",
+		"vendor/lib/lib.go",
+		"src/vendor/keep.go",
+		"web/node_modules/pkg/index.js",
+		"web/app.js",
+		"logs/a.log",
+		"logs/keep.log",
+	}
+
+	tests := []struct {
+		name     string
+		config   Config
+		expected []string
+	}{
+		{
+			name:     "double asterisk",
+			config:   Config{ExcludePatterns: []string{"build/**"}},
+			expected: []string{"logs/a.log", "logs/keep.log", "main.go", "src/build/gen.go", "src/vendor/keep.go", "vendor/lib/lib.go", "web/app.js", "web/node_modules/pkg/index.js"},
+		},
+		{
+			name:     "directory only and anchored",
+			config:   Config{ExcludePatterns: []string{"node_modules/", "/vendor"}},
+			expected: []string{"build/out/app", "build/out/app.log", "logs/a.log", "logs/keep.log", "main.go", "src/build/gen.go", "src/vendor/keep.go", "web/app.js"},
+		},
+		{
+			name:     "negation",
+			config:   Config{ExcludePatterns: []string{"*.log", "!keep.log"}},
+			expected: []string{"build/out/app", "logs/keep.log", "main.go", "src/build/gen.go", "src/vendor/keep.go", "vendor/lib/lib.go", "web/app.js", "web/node_modules/pkg/index.js"},
+		},
+		{
+			name:     "include relative path",
+			config:   Config{IncludePatterns: []string{"src/**/*.go"}},
+			expected: []string{"src/build/gen.go", "src/vendor/keep.go"},
+		},
+		{
+			name:     "include directory",
+			config:   Config{IncludePatterns: []string{"web/"}, ExcludePatterns: []string{"node_modules/"}},
+			expected: []string{"web/app.js"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range setup {
+				fullPath := filepath.Join(dir, f)
+				os.MkdirAll(filepath.Dir(fullPath), 0755)
+				os.WriteFile(fullPath, []byte("test"), 0644)
+			}
+
+			tt.config.RelativePaths = true
+			files, err := scanPaths(dir, tt.config)
+			if err != nil {
+				t.Fatalf("scanPaths failed: %v", err)
+			}
+			for i := range files {
+				files[i] = filepath.ToSlash(files[i])
+			}
+			sort.Strings(files)
+
+			if strings.Join(files, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got %v", tt.expected, files)
+			}
+		})
+	}
+}
+
+func TestFindFilesWithIgnoreFiles(t *testing.T) {
+	files := map[string]string{
+		".inventoryignore":            "*.tmp\n/secret/\n",
+		"a.txt":                       "",
+		"a.tmp":                       "",
+		"secret/key.pem":              "",
+		"sub/.inventoryignore":        "!keep.tmp\n*.bak\n/local/\n",
+		"sub/keep.tmp":                "",
+		"sub/drop.tmp":                "",
+		"sub/old.bak":                 "",
+		"sub/local/x.txt":             "",
+		"sub/secret/y.txt":            "",
+		"other/old.bak":               "",
+		"other/local/z.txt":           "",
+		"git/.gitignore":              "*.o\n",
+		"git/main.o":                  "",
+		"git/main.c":                  "",
+		"git/nested/.gitignore":       "!main.o\n",
+		"git/nested/main.o":           "",
+		"git/nested/.inventoryignore": "main.o\n",
+	}
+
+	tests := []struct {
+		name     string
+		config   Config
+		expected []string
+	}{
+		{
+			name: "inventoryignore only",
+			expected: []string{
+				"a.txt", "git/main.c", "git/main.o", "other/local/z.txt",
+				"other/old.bak", "sub/keep.tmp", "sub/secret/y.txt",
+			},
+		},
+		{
+			name:   "respect gitignore",
+			config: Config{RespectGitignore: true},
+			expected: []string{
+				"a.txt", "git/main.c", "other/local/z.txt", "other/old.bak", "sub/keep.tmp", "sub/secret/y.txt",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for f, content := range files {
+				fullPath := filepath.Join(dir, f)
+				os.MkdirAll(filepath.Dir(fullPath), 0755)
+				os.WriteFile(fullPath, []byte(content), 0644)
+			}
+
+			tt.config.RelativePaths = true
+			found, err := scanPaths(dir, tt.config)
+			if err != nil {
+				t.Fatalf("scanPaths failed: %v", err)
+			}
+			for i := range found {
+				found[i] = filepath.ToSlash(found[i])
+			}
+			sort.Strings(found)
+
+			if strings.Join(found, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got %v", tt.expected, found)
+			}
+		})
+	}
+}
+
+func TestIgnoreRulesScopedToSubtree(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "sub", inventoryIgnoreFile), []byte("/build\n"), 0644)
+
+	var root *ignoreRules
+	sub := root.enterDir(filepath.Join(dir, "sub"), "sub", []string{inventoryIgnoreFile})
+	if sub == root {
+		t.Fatal("Expected rules to be loaded for sub")
+	}
+
+	if !sub.ignored("sub/build", true) {
+		t.Error("sub/build should be ignored by sub/.inventoryignore")
+	}
+	if sub.ignored("sub/x/build", true) {
+		t.Error("Anchored pattern should only match directly under sub")
+	}
+	if root.ignored("build", true) {
+		t.Error("Rules from sub must not apply outside it")
+	}
+}
@@ -0,0 +1,790 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Text inventory files come in two flavours. Plain inventories hold one path
+// per line. Record inventories start with a magic line and a column list, followed
+// by one tab-separated record per file:
+//
+//	#file-inventory 1
+//	#columns path size mtime mode type hash
+//	docs/readme.txt	1234	2024-01-02T03:04:05Z	0644	f	sha256:9f86d0...
+//
+// Fields in record inventories are escaped so that paths containing tabs,
+// newlines or a leading '#' survive a round trip. JSON and JSON Lines
+// inventories are handled in inventory_json.go.
+
+const (
+	inventoryMagic   = "#file-inventory"
+	inventoryVersion = 1
+	columnsPrefix    = "#columns"
+)
+
+// Supported per-file attributes, in the order they are written
+const (
+	AttrSize   = "size"
+	AttrMtime  = "mtime"
+	AttrMode   = "mode"
+	AttrType   = "type"
+	AttrInode  = "inode"  // inode number, used by create --reuse to recognise unchanged files
+	AttrTarget = "target" // symlink target, written with --symlinks record or follow
+	AttrHash   = "hash"   // written when create --hash is used
+)
+
+// knownAttributes lists the attributes selectable with create --attrs
+var knownAttributes = []string{AttrSize, AttrMtime, AttrMode, AttrType, AttrInode}
+
+// knownColumns lists every column a record inventory may carry
+var knownColumns = []string{AttrSize, AttrMtime, AttrMode, AttrType, AttrInode, AttrTarget, AttrHash}
+
+// dirSuffix ends the path of directory entries, as in ls -F
+const dirSuffix = "/"
+
+// FileEntry is a single inventory record
+type FileEntry struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Mode    fs.FileMode // permission and setuid/setgid/sticky bits only
+	Type    string      // find(1)-style type letter: f, d, l, p, s, b, c or ?
+	Inode   uint64      // inode number, 0 where the platform has none
+	Target  string      // symlink target, empty for other entries
+	Hash    string      // content digest as "algo:hex", empty if not hashed
+
+	srcPath string // location on disk while scanning, never written out
+}
+
+// Inventory is a parsed inventory file
+type Inventory struct {
+	Header  *inventoryHeader // how the inventory was made, nil if not recorded
+	Columns []string         // attribute columns present, excluding path
+	Entries []FileEntry
+}
+
+// IsDir reports whether the entry is a directory
+func (e FileEntry) IsDir() bool {
+	return e.Type == "d" || strings.HasSuffix(e.Path, dirSuffix)
+}
+
+// HasColumn reports whether the inventory recorded the given attribute
+func (inv *Inventory) HasColumn(name string) bool {
+	return containsString(inv.Columns, name)
+}
+
+// parseAttributes validates a list of attribute names and returns them in
+// canonical order without duplicates
+func parseAttributes(attrs []string) ([]string, error) {
+	requested := make(map[string]bool)
+	for _, a := range attrs {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a == "" {
+			continue
+		}
+		if !isKnownAttribute(a) {
+			return nil, fmt.Errorf("unknown attribute %q (supported: %s)", a, strings.Join(knownAttributes, ","))
+		}
+		requested[a] = true
+	}
+
+	var result []string
+	for _, a := range knownAttributes {
+		if requested[a] {
+			result = append(result, a)
+		}
+	}
+	return result, nil
+}
+
+func isKnownAttribute(name string) bool {
+	return containsString(knownAttributes, name)
+}
+
+func isKnownColumn(name string) bool {
+	return containsString(knownColumns, name)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// fileTypes lists the type letters accepted by create --type
+var fileTypes = []string{"f", "d", "l", "p", "s", "b", "c"}
+
+// parseFileTypes validates and normalizes --type values. Like find -type,
+// letters may be given separately or comma-separated.
+func parseFileTypes(types []string) ([]string, error) {
+	var result []string
+	for _, t := range types {
+		for _, letter := range strings.Split(t, ",") {
+			letter = strings.TrimSpace(letter)
+			if letter == "" {
+				continue
+			}
+			if !containsString(fileTypes, letter) {
+				return nil, fmt.Errorf("unknown file type %q (supported: %s)", letter, strings.Join(fileTypes, ","))
+			}
+			if !containsString(result, letter) {
+				result = append(result, letter)
+			}
+		}
+	}
+	return result, nil
+}
+
+// fileTypeLetter maps a file mode to a find(1)-style type letter
+func fileTypeLetter(mode fs.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "f"
+	case mode&fs.ModeDir != 0:
+		return "d"
+	case mode&fs.ModeSymlink != 0:
+		return "l"
+	case mode&fs.ModeNamedPipe != 0:
+		return "p"
+	case mode&fs.ModeSocket != 0:
+		return "s"
+	case mode&fs.ModeCharDevice != 0:
+		return "c"
+	case mode&fs.ModeDevice != 0:
+		return "b"
+	default:
+		return "?"
+	}
+}
+
+// formatMode renders permission bits as a unix-style octal string
+func formatMode(mode fs.FileMode) string {
+	bits := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		bits |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		bits |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		bits |= 0o1000
+	}
+	return fmt.Sprintf("%04o", bits)
+}
+
+// parseMode is the inverse of formatMode
+func parseMode(s string) (fs.FileMode, error) {
+	bits, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || bits > 0o7777 {
+		return 0, fmt.Errorf("invalid mode %q", s)
+	}
+	mode := fs.FileMode(bits & 0o777)
+	if bits&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if bits&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if bits&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode, nil
+}
+
+// escapeField escapes characters that would break the record format
+func escapeField(s string) string {
+	if !strings.ContainsAny(s, "\\\t\n\r") && !strings.HasPrefix(s, "#") {
+		return s
+	}
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '#' && i == 0:
+			b.WriteString(`\#`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// unescapeField is the inverse of escapeField
+func unescapeField(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return "", fmt.Errorf("dangling escape in %q", s)
+		}
+		switch s[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case '#':
+			b.WriteByte('#')
+		default:
+			return "", fmt.Errorf("invalid escape \\%c in %q", s[i], s)
+		}
+	}
+	return b.String(), nil
+}
+
+// formatRecord renders an entry as a tab-separated record line
+func formatRecord(entry FileEntry, columns []string) string {
+	fields := make([]string, 0, len(columns)+1)
+	fields = append(fields, escapeField(entry.Path))
+	for _, col := range columns {
+		switch col {
+		case AttrSize:
+			fields = append(fields, strconv.FormatInt(entry.Size, 10))
+		case AttrMtime:
+			fields = append(fields, entry.ModTime.UTC().Format(time.RFC3339Nano))
+		case AttrMode:
+			fields = append(fields, formatMode(entry.Mode))
+		case AttrType:
+			fields = append(fields, entry.Type)
+		case AttrInode:
+			fields = append(fields, formatInode(entry.Inode))
+		case AttrTarget:
+			fields = append(fields, formatOptional(entry.Target))
+		case AttrHash:
+			fields = append(fields, formatOptional(entry.Hash))
+		}
+	}
+	return strings.Join(fields, "\t")
+}
+
+// formatOptional renders a possibly empty field, using "-" for empty
+func formatOptional(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return escapeField(s)
+}
+
+// parseOptional is the inverse of formatOptional
+func parseOptional(s string) string {
+	if s == "-" {
+		return ""
+	}
+	if v, err := unescapeField(s); err == nil {
+		return v
+	}
+	return s
+}
+
+// formatInode renders an inode number, using "-" where it is unknown
+func formatInode(ino uint64) string {
+	if ino == 0 {
+		return "-"
+	}
+	return strconv.FormatUint(ino, 10)
+}
+
+// parseInode is the inverse of formatInode
+func parseInode(s string) (uint64, error) {
+	if s == "-" {
+		return 0, nil
+	}
+	ino, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid inode %q", s)
+	}
+	return ino, nil
+}
+
+// parseRecord parses a tab-separated record line
+func parseRecord(line string, columns []string) (FileEntry, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != len(columns)+1 {
+		return FileEntry{}, fmt.Errorf("expected %d fields, got %d", len(columns)+1, len(fields))
+	}
+
+	var entry FileEntry
+	var err error
+	if entry.Path, err = unescapeField(fields[0]); err != nil {
+		return FileEntry{}, err
+	}
+
+	for i, col := range columns {
+		value := fields[i+1]
+		switch col {
+		case AttrSize:
+			if entry.Size, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return FileEntry{}, fmt.Errorf("invalid size %q", value)
+			}
+		case AttrMtime:
+			if entry.ModTime, err = time.Parse(time.RFC3339Nano, value); err != nil {
+				return FileEntry{}, fmt.Errorf("invalid mtime %q", value)
+			}
+		case AttrMode:
+			if entry.Mode, err = parseMode(value); err != nil {
+				return FileEntry{}, err
+			}
+		case AttrType:
+			entry.Type = value
+		case AttrInode:
+			if entry.Inode, err = parseInode(value); err != nil {
+				return FileEntry{}, err
+			}
+		case AttrTarget:
+			entry.Target = parseOptional(value)
+		case AttrHash:
+			entry.Hash = parseOptional(value)
+		}
+	}
+	return entry, nil
+}
+
+// Inventory output formats
+const (
+	formatText  = "text"  // plain path list, or record format when columns are present
+	formatJSON  = "json"  // a JSON array with one object per file
+	formatJSONL = "jsonl" // one JSON object per line
+)
+
+var inventoryFormats = []string{formatText, formatJSON, formatJSONL}
+
+// validateInventoryFormat checks that format is a supported output format
+func validateInventoryFormat(format string) error {
+	if !containsString(inventoryFormats, format) {
+		return fmt.Errorf("unsupported format %q (supported: %s)", format, strings.Join(inventoryFormats, ","))
+	}
+	return nil
+}
+
+// writeInventoryWithHeader writes entries to filename, starting with header
+// if it is not nil
+func writeInventoryWithHeader(filename, format string, columns []string, header *inventoryHeader, entries []FileEntry) error {
+	inv, err := createInventoryWithHeader(filename, format, columns, header)
+	if err != nil {
+		return err
+	}
+	defer inv.Close()
+
+	for _, entry := range entries {
+		if err := inv.Write(entry); err != nil {
+			return err
+		}
+	}
+	return inv.Close()
+}
+
+// entryEncoder writes the entries of one inventory format one at a time
+type entryEncoder interface {
+	writeHeader(w io.Writer) error
+	writeEntry(w io.Writer, entry FileEntry) error
+	writeFooter(w io.Writer) error
+}
+
+func newEntryEncoder(format string, columns []string, header *inventoryHeader) entryEncoder {
+	switch format {
+	case formatJSON, formatJSONL:
+		return &jsonEncoder{lines: format == formatJSONL, columns: columns, header: header}
+	default:
+		return textEncoder{columns: columns, header: header}
+	}
+}
+
+// inventoryWriter streams entries to an inventory file, so callers never
+// need to hold the whole inventory in memory
+type inventoryWriter struct {
+	f      *os.File
+	zw     io.WriteCloser // compresses into f
+	w      *bufio.Writer
+	enc    entryEncoder
+	count  int
+	closed bool
+}
+
+// createInventoryWithHeader creates filename and writes the header for
+// format, including the description of the scan in header if it is not nil.
+// The output is compressed when filename ends in .gz or .zst.
+func createInventoryWithHeader(filename, format string, columns []string, header *inventoryHeader) (*inventoryWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	zw, err := newCompressedWriter(f, compressionOf(filename))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	inv := &inventoryWriter{f: f, zw: zw, w: bufio.NewWriter(zw), enc: newEntryEncoder(format, columns, header)}
+	if err := inv.enc.writeHeader(inv.w); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write file entry: %w", err)
+	}
+	return inv, nil
+}
+
+// Write appends entry to the inventory
+func (inv *inventoryWriter) Write(entry FileEntry) error {
+	if err := inv.enc.writeEntry(inv.w, entry); err != nil {
+		return fmt.Errorf("failed to write file entry: %w", err)
+	}
+	inv.count++
+	return nil
+}
+
+// Count returns the number of entries written so far
+func (inv *inventoryWriter) Count() int {
+	return inv.count
+}
+
+// Close finishes the inventory and closes the file. It is safe to call more
+// than once, so it can also be deferred.
+func (inv *inventoryWriter) Close() error {
+	if inv.closed {
+		return nil
+	}
+	inv.closed = true
+
+	if err := inv.enc.writeFooter(inv.w); err != nil {
+		inv.f.Close()
+		return fmt.Errorf("failed to write file entry: %w", err)
+	}
+	if err := inv.w.Flush(); err != nil {
+		inv.f.Close()
+		return fmt.Errorf("failed to write file entry: %w", err)
+	}
+	if err := inv.zw.Close(); err != nil {
+		inv.f.Close()
+		return fmt.Errorf("failed to compress inventory: %w", err)
+	}
+	return inv.f.Close()
+}
+
+// textEncoder writes plain path lists, or records when there are columns
+// or a header
+type textEncoder struct {
+	columns []string
+	header  *inventoryHeader
+}
+
+func (e textEncoder) record() bool {
+	return len(e.columns) > 0 || e.header != nil
+}
+
+func (e textEncoder) writeHeader(w io.Writer) error {
+	if !e.record() {
+		return nil
+	}
+	lines := []string{fmt.Sprintf("%s %d", inventoryMagic, inventoryVersion)}
+	if e.header != nil {
+		headerLines, err := e.header.textLines()
+		if err != nil {
+			return err
+		}
+		lines = append(lines, headerLines...)
+	}
+	lines = append(lines, strings.TrimSpace(columnsPrefix+" path "+strings.Join(e.columns, " ")))
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+func (e textEncoder) writeEntry(w io.Writer, entry FileEntry) error {
+	line := entry.Path
+	if e.record() {
+		line = formatRecord(entry, e.columns)
+	}
+	_, err := fmt.Fprintln(w, line)
+	return err
+}
+
+func (e textEncoder) writeFooter(w io.Writer) error {
+	return nil
+}
+
+// readInventory reads an inventory file, detecting whether it holds a plain
+// path list, records, a JSON array or JSON lines, and whether it is
+// compressed
+func readInventory(filename string) (*Inventory, error) {
+	reader, err := openInventoryFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	switch detectInventoryFormat(reader.Reader) {
+	case formatJSON:
+		return readJSONInventory(reader, false)
+	case formatJSONL:
+		return readJSONInventory(reader, true)
+	default:
+		return readTextInventory(reader)
+	}
+}
+
+// entrySource yields entries one at a time, returning io.EOF after the last
+type entrySource interface {
+	Next() (FileEntry, error)
+}
+
+// inventoryReader streams the entries of an inventory file without loading
+// it into memory
+type inventoryReader struct {
+	Header  *inventoryHeader
+	Columns []string
+
+	f       *inventoryFile
+	src     entrySource
+	pending *FileEntry // entry read ahead while looking for the columns
+	err     error
+}
+
+// openInventory opens an inventory file for streaming. The columns of text
+// inventories come from their header; JSON inventories are read once up
+// front to collect them, since each object only lists its own attributes.
+// Header and Columns are set once it returns.
+func openInventory(filename string) (*inventoryReader, error) {
+	reader, err := openInventoryFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &inventoryReader{f: reader}
+	switch format := detectInventoryFormat(reader.Reader); format {
+	case formatJSON, formatJSONL:
+		if r.Columns, r.Header, err = scanJSONInventory(filename, format == formatJSONL); err == nil {
+			r.src, err = newJSONDecoder(reader, format == formatJSONL)
+		}
+	default:
+		d := newTextDecoder(reader)
+		r.src = d
+		// The header precedes the first entry
+		entry, nextErr := d.Next()
+		if nextErr == nil {
+			r.pending = &entry
+		} else {
+			r.err = nextErr
+		}
+		r.Header = d.inv.Header
+		r.Columns = d.inv.Columns
+	}
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	if r.err != nil && r.err != io.EOF {
+		reader.Close()
+		return nil, r.err
+	}
+	return r, nil
+}
+
+// scanJSONInventory reads a JSON inventory and returns the attributes it
+// uses and its header, if any
+func scanJSONInventory(filename string, lines bool) ([]string, *inventoryHeader, error) {
+	reader, err := openInventoryFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+
+	d, err := newJSONDecoder(reader, lines)
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		if _, err := d.Next(); err == io.EOF {
+			return d.Columns(), d.header, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// Next returns the next entry, or io.EOF after the last one
+func (r *inventoryReader) Next() (FileEntry, error) {
+	if r.pending != nil {
+		entry := *r.pending
+		r.pending = nil
+		return entry, nil
+	}
+	if r.err != nil {
+		return FileEntry{}, r.err
+	}
+	return r.src.Next()
+}
+
+// Close closes the underlying file
+func (r *inventoryReader) Close() error {
+	return r.f.Close()
+}
+
+// detectInventoryFormat peeks at the start of r to tell JSON inventories
+// from text ones without consuming any input
+func detectInventoryFormat(r *bufio.Reader) string {
+	head, _ := r.Peek(r.Size())
+	head = bytes.TrimLeft(head, " \t\r\n")
+	firstLine, _, _ := bytes.Cut(head, []byte("\n"))
+	firstLine = bytes.TrimSpace(firstLine)
+
+	switch {
+	case bytes.HasPrefix(firstLine, []byte("{")) && isJSONElement(firstLine):
+		return formatJSONL
+	case bytes.Equal(firstLine, []byte("[")) || bytes.HasPrefix(firstLine, []byte("[{")) ||
+		bytes.Equal(firstLine, []byte("[]")):
+		return formatJSON
+	default:
+		return formatText
+	}
+}
+
+// isJSONElement reports whether line is a JSON object that can start a JSON
+// Lines inventory: an entry with a path, or the header. Any other line, even
+// valid JSON, is a path in a plain inventory.
+func isJSONElement(line []byte) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return false
+	}
+	_, hasPath := fields["path"]
+	_, hasHeader := fields["header"]
+	return hasPath || hasHeader
+}
+
+// readTextInventory parses a plain or record inventory
+func readTextInventory(r io.Reader) (*Inventory, error) {
+	d := newTextDecoder(r)
+	inv := &Inventory{}
+	for {
+		entry, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		inv.Entries = append(inv.Entries, entry)
+	}
+	inv.Header = d.inv.Header
+	inv.Columns = d.inv.Columns
+	return inv, nil
+}
+
+// textDecoder reads a plain or record inventory one entry at a time
+type textDecoder struct {
+	scanner *bufio.Scanner
+	inv     Inventory // holds the columns from the header
+	lineNum int
+	record  bool
+}
+
+func newTextDecoder(r io.Reader) *textDecoder {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	return &textDecoder{scanner: scanner}
+}
+
+// Next returns the next entry, or io.EOF after the last one. Header lines
+// are consumed on the way, so the columns are known once the first entry
+// has been read.
+func (d *textDecoder) Next() (FileEntry, error) {
+	for d.scanner.Scan() {
+		line := d.scanner.Text()
+		d.lineNum++
+
+		if d.lineNum == 1 && strings.HasPrefix(line, inventoryMagic+" ") {
+			version, err := strconv.Atoi(strings.TrimPrefix(line, inventoryMagic+" "))
+			if err != nil {
+				return FileEntry{}, fmt.Errorf("line %d: malformed inventory header %q", d.lineNum, line)
+			}
+			if version > inventoryVersion {
+				return FileEntry{}, fmt.Errorf("unsupported inventory version %d", version)
+			}
+			d.record = true
+			continue
+		}
+
+		if d.record && strings.HasPrefix(line, "#") {
+			if err := d.inv.parseHeaderLine(line); err != nil {
+				return FileEntry{}, fmt.Errorf("line %d: %w", d.lineNum, err)
+			}
+			continue
+		}
+
+		if line == "" { // Skip empty lines
+			continue
+		}
+
+		if !d.record {
+			return FileEntry{Path: line}, nil
+		}
+
+		entry, err := parseRecord(line, d.inv.Columns)
+		if err != nil {
+			return FileEntry{}, fmt.Errorf("line %d: %w", d.lineNum, err)
+		}
+		return entry, nil
+	}
+
+	if err := d.scanner.Err(); err != nil {
+		return FileEntry{}, fmt.Errorf("error reading file: %w", err)
+	}
+	return FileEntry{}, io.EOF
+}
+
+// parseHeaderLine interprets a '#' line at the top of a record inventory
+func (inv *Inventory) parseHeaderLine(line string) error {
+	header := inv.Header
+	if header == nil {
+		header = &inventoryHeader{}
+	}
+	if ok, err := header.parseTextLine(line); ok {
+		inv.Header = header
+		return err
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != columnsPrefix {
+		// Unknown header lines are ignored for forward compatibility
+		return nil
+	}
+	if len(fields) < 2 || fields[1] != "path" {
+		return fmt.Errorf("column list must start with path: %q", line)
+	}
+
+	inv.Columns = nil
+	for _, col := range fields[2:] {
+		if !isKnownColumn(col) {
+			return fmt.Errorf("unknown column %q", col)
+		}
+		inv.Columns = append(inv.Columns, col)
+	}
+	return nil
+}
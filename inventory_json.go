@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// jsonEntry is the JSON form of a FileEntry. Attributes that were not
+// collected are omitted rather than written as zero values.
+type jsonEntry struct {
+	Path   string  `json:"path"`
+	Size   *int64  `json:"size,omitempty"`
+	Mtime  string  `json:"mtime,omitempty"`
+	Mode   string  `json:"mode,omitempty"`
+	Type   string  `json:"type,omitempty"`
+	Inode  *uint64 `json:"inode,omitempty"`
+	Target string  `json:"target,omitempty"`
+	Hash   string  `json:"hash,omitempty"`
+}
+
+func toJSONEntry(entry FileEntry, columns []string) jsonEntry {
+	je := jsonEntry{Path: entry.Path}
+	for _, col := range columns {
+		switch col {
+		case AttrSize:
+			size := entry.Size
+			je.Size = &size
+		case AttrMtime:
+			je.Mtime = entry.ModTime.UTC().Format(time.RFC3339Nano)
+		case AttrMode:
+			je.Mode = formatMode(entry.Mode)
+		case AttrType:
+			je.Type = entry.Type
+		case AttrInode:
+			ino := entry.Inode
+			je.Inode = &ino
+		case AttrTarget:
+			je.Target = entry.Target
+		case AttrHash:
+			je.Hash = entry.Hash
+		}
+	}
+	return je
+}
+
+// toFileEntry converts a decoded object and reports which columns it carried
+func (je jsonEntry) toFileEntry() (FileEntry, []string, error) {
+	if je.Path == "" {
+		return FileEntry{}, nil, fmt.Errorf("missing path")
+	}
+
+	entry := FileEntry{Path: je.Path, Type: je.Type, Target: je.Target, Hash: je.Hash}
+	var columns []string
+	if je.Size != nil {
+		entry.Size = *je.Size
+		columns = append(columns, AttrSize)
+	}
+	if je.Mtime != "" {
+		mtime, err := time.Parse(time.RFC3339Nano, je.Mtime)
+		if err != nil {
+			return FileEntry{}, nil, fmt.Errorf("invalid mtime %q", je.Mtime)
+		}
+		entry.ModTime = mtime
+		columns = append(columns, AttrMtime)
+	}
+	if je.Mode != "" {
+		mode, err := parseMode(je.Mode)
+		if err != nil {
+			return FileEntry{}, nil, err
+		}
+		entry.Mode = mode
+		columns = append(columns, AttrMode)
+	}
+	if je.Type != "" {
+		columns = append(columns, AttrType)
+	}
+	if je.Inode != nil {
+		entry.Inode = *je.Inode
+		columns = append(columns, AttrInode)
+	}
+	if je.Target != "" {
+		columns = append(columns, AttrTarget)
+	}
+	if je.Hash != "" {
+		columns = append(columns, AttrHash)
+	}
+	return entry, columns, nil
+}
+
+// jsonEncoder writes a JSON array with one object per line, or JSON Lines
+// when lines is set. The separator is written before each element, since
+// the last one is not known until the footer.
+type jsonEncoder struct {
+	lines   bool
+	columns []string
+	header  *inventoryHeader
+	count   int
+}
+
+// jsonHeader is the element that starts JSON inventories with a header
+type jsonHeader struct {
+	Header *inventoryHeader `json:"header"`
+}
+
+func (e *jsonEncoder) writeHeader(w io.Writer) error {
+	if !e.lines {
+		if _, err := io.WriteString(w, "[\n"); err != nil {
+			return err
+		}
+	}
+	if e.header == nil {
+		return nil
+	}
+	data, err := json.Marshal(jsonHeader{Header: e.header})
+	if err != nil {
+		return fmt.Errorf("failed to encode header: %w", err)
+	}
+	return e.writeElement(w, data)
+}
+
+func (e *jsonEncoder) writeEntry(w io.Writer, entry FileEntry) error {
+	data, err := json.Marshal(toJSONEntry(entry, e.columns))
+	if err != nil {
+		return fmt.Errorf("failed to encode file entry: %w", err)
+	}
+	return e.writeElement(w, data)
+}
+
+// writeElement writes one array element or line
+func (e *jsonEncoder) writeElement(w io.Writer, data []byte) error {
+	prefix, suffix := "", "\n"
+	if !e.lines {
+		prefix, suffix = "  ", ""
+		if e.count > 0 {
+			prefix = ",\n  "
+		}
+	}
+	e.count++
+	_, err := fmt.Fprintf(w, "%s%s%s", prefix, data, suffix)
+	return err
+}
+
+func (e *jsonEncoder) writeFooter(w io.Writer) error {
+	if e.lines {
+		return nil
+	}
+	closing := "]\n"
+	if e.count > 0 {
+		closing = "\n]\n"
+	}
+	_, err := io.WriteString(w, closing)
+	return err
+}
+
+// readJSONInventory parses a JSON array inventory, or JSON Lines when lines
+// is set. The inventory columns are the union of attributes seen.
+func readJSONInventory(r io.Reader, lines bool) (*Inventory, error) {
+	d, err := newJSONDecoder(r, lines)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &Inventory{}
+	for {
+		entry, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		inv.Entries = append(inv.Entries, entry)
+	}
+	inv.Header = d.header
+	inv.Columns = d.Columns()
+	return inv, nil
+}
+
+// jsonDecoder reads a JSON array or JSON Lines inventory one entry at a time
+type jsonDecoder struct {
+	decoder *json.Decoder
+	lines   bool
+	n       int
+	seen    map[string]bool
+	header  *inventoryHeader // from a leading header element
+	done    bool
+}
+
+// jsonElement is an element of a JSON inventory: an entry, or the header
+type jsonElement struct {
+	jsonEntry
+	Header *inventoryHeader `json:"header"`
+}
+
+func newJSONDecoder(r io.Reader, lines bool) (*jsonDecoder, error) {
+	decoder := json.NewDecoder(r)
+	if !lines {
+		if tok, err := decoder.Token(); err != nil || tok != json.Delim('[') {
+			return nil, fmt.Errorf("expected JSON array of file entries")
+		}
+	}
+	return &jsonDecoder{decoder: decoder, lines: lines, seen: make(map[string]bool)}, nil
+}
+
+// Next returns the next entry, or io.EOF after the last one
+func (d *jsonDecoder) Next() (FileEntry, error) {
+	if d.done {
+		return FileEntry{}, io.EOF
+	}
+	if !d.decoder.More() {
+		d.done = true
+		if !d.lines {
+			if _, err := d.decoder.Token(); err != nil {
+				return FileEntry{}, fmt.Errorf("unterminated JSON array: %w", err)
+			}
+		}
+		return FileEntry{}, io.EOF
+	}
+
+	d.n++
+	var elem jsonElement
+	if err := d.decoder.Decode(&elem); err != nil {
+		return FileEntry{}, fmt.Errorf("entry %d: %w", d.n, err)
+	}
+	if elem.Header != nil && d.n == 1 {
+		d.header = elem.Header
+		return d.Next()
+	}
+	entry, columns, err := elem.toFileEntry()
+	if err != nil {
+		return FileEntry{}, fmt.Errorf("entry %d: %w", d.n, err)
+	}
+	for _, col := range columns {
+		d.seen[col] = true
+	}
+	return entry, nil
+}
+
+// Columns returns the attributes seen so far, in canonical order
+func (d *jsonDecoder) Columns() []string {
+	var columns []string
+	for _, col := range knownColumns {
+		if d.seen[col] {
+			columns = append(columns, col)
+		}
+	}
+	return columns
+}
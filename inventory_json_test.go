@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONInventoryRoundTrip(t *testing.T) {
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	columns := []string{AttrSize, AttrMtime, AttrInode, AttrHash}
+	entries := []FileEntry{
+		{Path: "empty.txt", Size: 0, ModTime: mtime, Inode: 7, Hash: "sha256:aa"},
+		{Path: "sub/data.bin", Size: 42, ModTime: mtime, Inode: 8, Hash: "sha256:bb"},
+	}
+
+	for _, format := range []string{formatJSON, formatJSONL} {
+		t.Run(format, func(t *testing.T) {
+			testFile := filepath.Join(t.TempDir(), "inventory."+format)
+			if err := writeInventory(testFile, format, columns, entries); err != nil {
+				t.Fatalf("writeInventory failed: %v", err)
+			}
+
+			inv, err := readInventory(testFile)
+			if err != nil {
+				t.Fatalf("readInventory failed: %v", err)
+			}
+			if strings.Join(inv.Columns, ",") != strings.Join(columns, ",") {
+				t.Errorf("Expected columns %v, got %v", columns, inv.Columns)
+			}
+			if len(inv.Entries) != len(entries) {
+				t.Fatalf("Expected %d entries, got %d", len(entries), len(inv.Entries))
+			}
+			for i, want := range entries {
+				got := inv.Entries[i]
+				if got.Path != want.Path || got.Size != want.Size || !got.ModTime.Equal(want.ModTime) || got.Inode != want.Inode || got.Hash != want.Hash {
+					t.Errorf("Entry %d: expected %+v, got %+v", i, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteJSONInventoryIsValidJSON(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "inventory.json")
+	entries := []FileEntry{{Path: "a.txt", Size: 0}, {Path: "b.txt", Size: 3}}
+
+	if err := writeInventory(testFile, formatJSON, []string{AttrSize}, entries); err != nil {
+		t.Fatalf("writeInventory failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(testFile)
+	var decoded []map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Output is not a JSON array: %v\n%s", err, data)
+	}
+	if len(decoded) != 2 {
+		t.Fatalf("Expected 2 objects, got %d", len(decoded))
+	}
+	if _, ok := decoded[0]["size"]; !ok {
+		t.Error("Zero size should still be written when the size column is collected")
+	}
+	if _, ok := decoded[0]["mtime"]; ok {
+		t.Error("Attributes that were not collected should be omitted")
+	}
+}
+
+func TestWriteJSONInventoryEmpty(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "inventory.json")
+	if err := writeInventory(testFile, formatJSON, nil, nil); err != nil {
+		t.Fatalf("writeInventory failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(testFile)
+	if string(data) != "[\n]\n" {
+		t.Errorf("Expected an empty array, got %q", data)
+	}
+}
+
+func TestDetectInventoryFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"plain", "a.txt\nb.txt\n", formatText},
+		{"record", "#file-inventory 1\n#columns path size\na.txt\t1\n", formatText},
+		{"json array", "[\n  {\"path\":\"a.txt\"}\n]\n", formatJSON},
+		{"compact json array", "[{\"path\":\"a.txt\"}]", formatJSON},
+		{"empty json array", "[]\n", formatJSON},
+		{"json lines", "{\"path\":\"a.txt\"}\n{\"path\":\"b.txt\"}\n", formatJSONL},
+		{"json lines with header", "{\"header\":{\"host\":\"h\"}}\n{\"path\":\"a.txt\"}\n", formatJSONL},
+		{"brace in plain path", "{draft}.txt\n", formatText},
+		{"json object as plain path", "{}\n{\"a\":1}\n", formatText},
+		{"empty", "", formatText},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testFile := filepath.Join(t.TempDir(), "inventory")
+			os.WriteFile(testFile, []byte(tt.content), 0644)
+
+			f, err := os.Open(testFile)
+			if err != nil {
+				t.Fatalf("Failed to open test file: %v", err)
+			}
+			defer f.Close()
+
+			if format := detectInventoryFormat(bufio.NewReader(f)); format != tt.expected {
+				t.Errorf("Expected format %s, got %s", tt.expected, format)
+			}
+		})
+	}
+}
+
+func TestComputeDiffMixedFormats(t *testing.T) {
+	dir := t.TempDir()
+	file1 := filepath.Join(dir, "inv1.jsonl")
+	file2 := filepath.Join(dir, "inv2.txt")
+	os.WriteFile(file1, []byte("{\"path\":\"a.txt\",\"size\":1}\n{\"path\":\"b.txt\",\"size\":2}\n"), 0644)
+	os.WriteFile(file2, []byte("#file-inventory 1\n#columns path size\na.txt\t1\nb.txt\t5\n"), 0644)
+
+	inv1, err := readInventory(file1)
+	if err != nil {
+		t.Fatalf("readInventory failed: %v", err)
+	}
+	inv2, err := readInventory(file2)
+	if err != nil {
+		t.Fatalf("readInventory failed: %v", err)
+	}
+
+	rows := computeDiff(inv1, inv2, comparableColumns(inv1, inv2))
+	if len(rows) != 1 || rows[0].Path != "b.txt" || rows[0].Status != statusModified {
+		t.Errorf("Expected b.txt to be modified, got %+v", rows)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeInventory writes entries to filename in the given format, without a
+// header
+func writeInventory(filename, format string, columns []string, entries []FileEntry) error {
+	return writeInventoryWithHeader(filename, format, columns, nil, entries)
+}
+
+func TestParseAttributes(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       []string
+		expected    []string
+		expectError bool
+	}{
+		{
+			name:     "empty",
+			input:    []string{},
+			expected: nil,
+		},
+		{
+			name:     "canonical order and dedupe",
+			input:    []string{"type", "size", "SIZE", "mtime"},
+			expected: []string{"size", "mtime", "type"},
+		},
+		{
+			name:        "unknown attribute",
+			input:       []string{"size", "owner"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs, err := parseAttributes(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if strings.Join(attrs, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got %v", tt.expected, attrs)
+			}
+		})
+	}
+}
+
+func TestParseFileTypes(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       []string
+		expected    []string
+		expectError bool
+	}{
+		{
+			name:     "empty",
+			input:    []string{},
+			expected: nil,
+		},
+		{
+			name:     "comma separated and repeated",
+			input:    []string{"f,l", "p", "f"},
+			expected: []string{"f", "l", "p"},
+		},
+		{
+			name:        "unknown type",
+			input:       []string{"f,x"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			types, err := parseFileTypes(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if strings.Join(types, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got %v", tt.expected, types)
+			}
+		})
+	}
+}
+
+func TestWriteReadInventoryRoundTrip(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "inventory.txt")
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 600, time.UTC)
+	columns := []string{AttrSize, AttrMtime, AttrMode, AttrType, AttrInode, AttrTarget}
+	entries := []FileEntry{
+		{Path: "docs/readme.txt", Size: 1234, ModTime: mtime, Mode: 0644, Type: "f", Inode: 1 << 40},
+		{Path: "bin/tool", Size: 0, ModTime: mtime, Mode: 0755 | os.ModeSetuid, Type: "f"},
+		{Path: "#weird\tname\nwith\\escapes", Size: 7, ModTime: mtime, Mode: 0600, Type: "l", Target: "../odd\ttarget"},
+	}
+
+	if err := writeInventory(testFile, formatText, columns, entries); err != nil {
+		t.Fatalf("writeInventory failed: %v", err)
+	}
+
+	inv, err := readInventory(testFile)
+	if err != nil {
+		t.Fatalf("readInventory failed: %v", err)
+	}
+
+	if strings.Join(inv.Columns, ",") != strings.Join(columns, ",") {
+		t.Errorf("Expected columns %v, got %v", columns, inv.Columns)
+	}
+	if len(inv.Entries) != len(entries) {
+		t.Fatalf("Expected %d entries, got %d", len(entries), len(inv.Entries))
+	}
+	for i, want := range entries {
+		got := inv.Entries[i]
+		if got.Path != want.Path || got.Size != want.Size || !got.ModTime.Equal(want.ModTime) ||
+			got.Mode != want.Mode || got.Type != want.Type || got.Inode != want.Inode || got.Target != want.Target {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, want, got)
+		}
+	}
+}
+
+func TestWriteInventoryWithoutColumnsIsPlain(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "inventory.txt")
+	entries := []FileEntry{{Path: "a.txt"}, {Path: "sub/b.txt"}}
+
+	if err := writeInventory(testFile, formatText, nil, entries); err != nil {
+		t.Fatalf("writeInventory failed: %v", err)
+	}
+
+	data, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read test file: %v", err)
+	}
+	if string(data) != "a.txt\nsub/b.txt\n" {
+		t.Errorf("Expected plain path list, got %q", string(data))
+	}
+}
+
+func TestReadInventoryPlain(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "plain.txt")
+	os.WriteFile(testFile, []byte("a.txt\n#not-a-header.txt\n\nsub/b.txt\n"), 0644)
+
+	inv, err := readInventory(testFile)
+	if err != nil {
+		t.Fatalf("readInventory failed: %v", err)
+	}
+	if len(inv.Columns) != 0 {
+		t.Errorf("Expected no columns for plain inventory, got %v", inv.Columns)
+	}
+	if len(inv.Entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(inv.Entries))
+	}
+	if inv.Entries[1].Path != "#not-a-header.txt" {
+		t.Errorf("Expected literal path in plain inventory, got %q", inv.Entries[1].Path)
+	}
+}
+
+func TestOpenInventoryStreamsEntries(t *testing.T) {
+	dir := t.TempDir()
+	entries := []FileEntry{{Path: "a.txt", Size: 1}, {Path: "b.txt", Size: 2, Hash: "md5:00"}}
+
+	for _, format := range inventoryFormats {
+		t.Run(format, func(t *testing.T) {
+			testFile := filepath.Join(dir, "inventory."+format)
+			if err := writeInventory(testFile, format, []string{AttrSize, AttrHash}, entries); err != nil {
+				t.Fatalf("writeInventory failed: %v", err)
+			}
+
+			r, err := openInventory(testFile)
+			if err != nil {
+				t.Fatalf("openInventory failed: %v", err)
+			}
+			defer r.Close()
+
+			// Columns must be known before the first entry is read
+			if strings.Join(r.Columns, ",") != "size,hash" {
+				t.Errorf("Expected columns size,hash, got %v", r.Columns)
+			}
+
+			var got []FileEntry
+			for {
+				entry, err := r.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Next failed: %v", err)
+				}
+				got = append(got, entry)
+			}
+			if len(got) != 2 || got[1].Path != "b.txt" || got[1].Size != 2 || got[1].Hash != "md5:00" {
+				t.Errorf("Unexpected entries: %+v", got)
+			}
+		})
+	}
+}
+
+func TestReadInventoryMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name:    "future version",
+			content: "#file-inventory 99\n#columns path\na.txt\n",
+		},
+		{
+			name:    "unknown column",
+			content: "#file-inventory 1\n#columns path owner\na.txt\troot\n",
+		},
+		{
+			name:    "wrong field count",
+			content: "#file-inventory 1\n#columns path size\na.txt\n",
+		},
+		{
+			name:    "bad size",
+			content: "#file-inventory 1\n#columns path size\na.txt\tbig\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testFile := filepath.Join(t.TempDir(), "bad.txt")
+			os.WriteFile(testFile, []byte(tt.content), 0644)
+
+			if _, err := readInventory(testFile); err == nil {
+				t.Error("Expected error but got none")
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timestamps that --newer and --older can compare
+const (
+	timeFieldMtime = "mtime"
+	timeFieldCtime = "ctime" // inode change time, where the platform records it
+)
+
+var timeFields = []string{timeFieldMtime, timeFieldCtime}
+
+// validateTimeField checks that field is empty or a supported timestamp
+func validateTimeField(field string) error {
+	if field == "" || field == timeFieldMtime {
+		return nil
+	}
+	if field == timeFieldCtime {
+		if !ctimeSupported {
+			return fmt.Errorf("ctime is not available on this platform")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported time field %q (supported: %s)", field, strings.Join(timeFields, ","))
+}
+
+// entryPredicates holds the size and time conditions from a Config
+type entryPredicates struct {
+	minSize   int64
+	maxSize   int64 // -1 for no limit
+	newer     time.Time
+	older     time.Time
+	timeField string
+}
+
+func newEntryPredicates(config Config) entryPredicates {
+	p := entryPredicates{
+		minSize:   config.MinSize,
+		maxSize:   -1,
+		newer:     config.NewerThan,
+		older:     config.OlderThan,
+		timeField: config.TimeField,
+	}
+	if config.HasMaxSize {
+		p.maxSize = config.MaxSize
+	}
+	return p
+}
+
+// active reports whether any condition is set, so that file info is needed
+func (p entryPredicates) active() bool {
+	return p.minSize > 0 || p.maxSize >= 0 || !p.newer.IsZero() || !p.older.IsZero()
+}
+
+// match reports whether a file with the given info meets every condition.
+// Sizes are inclusive bounds; times must be strictly newer or older, as
+// with find -newer.
+func (p entryPredicates) match(info fs.FileInfo) bool {
+	if info.Size() < p.minSize || (p.maxSize >= 0 && info.Size() > p.maxSize) {
+		return false
+	}
+	if p.newer.IsZero() && p.older.IsZero() {
+		return true
+	}
+
+	t := fileTime(info, p.timeField)
+	if !p.newer.IsZero() && !t.After(p.newer) {
+		return false
+	}
+	if !p.older.IsZero() && !t.Before(p.older) {
+		return false
+	}
+	return true
+}
+
+// fileTime returns the timestamp of info selected by field
+func fileTime(info fs.FileInfo, field string) time.Time {
+	if field == timeFieldCtime {
+		if ctime, ok := changeTime(info); ok {
+			return ctime
+		}
+	}
+	return info.ModTime()
+}
+
+// Layouts accepted for absolute times, interpreted in local time unless
+// they carry a zone
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseTimeSpec turns a --newer or --older argument into a point in time.
+// The argument may be a duration before now ("90m", "36h", "7d", "2w"), an
+// absolute date or time ("2024-05-01", "2024-05-01 12:00", RFC 3339) or the
+// path of a reference file, whose timestamp selected by field is used.
+func parseTimeSpec(spec, field string, now time.Time) (time.Time, error) {
+	if d, ok := parseAge(spec); ok {
+		return now.Add(-d), nil
+	}
+
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, spec, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	info, err := os.Stat(spec)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a duration, a date nor a readable reference file", spec)
+	}
+	return fileTime(info, field), nil
+}
+
+// parseAge parses a non-negative duration, also accepting whole days and
+// weeks as "7d" and "2w"
+func parseAge(s string) (time.Duration, bool) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, found := strings.CutSuffix(s, suffix); found {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return 0, false
+			}
+			return time.Duration(count) * unit, true
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, false
+	}
+	return d, true
+}
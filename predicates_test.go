@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeSpec(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.Local)
+	ref := filepath.Join(t.TempDir(), "ref")
+	os.WriteFile(ref, nil, 0644)
+	refTime := time.Date(2024, 3, 1, 8, 30, 0, 0, time.Local)
+	os.Chtimes(ref, refTime, refTime)
+
+	tests := []struct {
+		spec        string
+		expected    time.Time
+		expectError bool
+	}{
+		{spec: "90m", expected: now.Add(-90 * time.Minute)},
+		{spec: "36h", expected: now.Add(-36 * time.Hour)},
+		{spec: "7d", expected: now.AddDate(0, 0, -7)},
+		{spec: "2w", expected: now.AddDate(0, 0, -14)},
+		{spec: "2024-05-01", expected: time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)},
+		{spec: "2024-05-01 13:45", expected: time.Date(2024, 5, 1, 13, 45, 0, 0, time.Local)},
+		{spec: "2024-05-01T13:45:10Z", expected: time.Date(2024, 5, 1, 13, 45, 10, 0, time.UTC)},
+		{spec: ref, expected: refTime},
+		{spec: "-5d", expectError: true},
+		{spec: "yesterday", expectError: true},
+		{spec: filepath.Join(t.TempDir(), "missing"), expectError: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTimeSpec(tt.spec, timeFieldMtime, now)
+		if tt.expectError {
+			if err == nil {
+				t.Errorf("parseTimeSpec(%q): expected error, got %v", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tt.expected) {
+			t.Errorf("parseTimeSpec(%q) = %v, %v; expected %v", tt.spec, got, err, tt.expected)
+		}
+	}
+}
+
+func TestFindFilesWithPredicates(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	files := []struct {
+		name string
+		size int
+		age  time.Duration
+	}{
+		{"small-new.txt", 10, time.Hour},
+		{"small-old.txt", 10, 30 * 24 * time.Hour},
+		{"big-new.bin", 4096, 2 * time.Hour},
+		{"big-old.bin", 4096, 60 * 24 * time.Hour},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		os.WriteFile(path, make([]byte, f.size), 0644)
+		mtime := now.Add(-f.age)
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	tests := []struct {
+		name     string
+		config   Config
+		expected []string
+	}{
+		{"min size", Config{MinSize: 1024}, []string{"big-new.bin", "big-old.bin"}},
+		{"max size", Config{MaxSize: 10, HasMaxSize: true}, []string{"small-new.txt", "small-old.txt"}},
+		{"newer", Config{NewerThan: now.Add(-7 * 24 * time.Hour)}, []string{"big-new.bin", "small-new.txt"}},
+		{"older", Config{OlderThan: now.Add(-7 * 24 * time.Hour)}, []string{"big-old.bin", "small-old.txt"}},
+		{"combined", Config{MinSize: 1024, NewerThan: now.Add(-24 * time.Hour)}, []string{"big-new.bin"}},
+		{"time window", Config{NewerThan: now.Add(-45 * 24 * time.Hour), OlderThan: now.Add(-24 * time.Hour)}, []string{"small-old.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.RelativePaths = true
+			found, err := scanPaths(dir, tt.config)
+			if err != nil {
+				t.Fatalf("scanPaths failed: %v", err)
+			}
+			sort.Strings(found)
+			if strings.Join(found, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got %v", tt.expected, found)
+			}
+		})
+	}
+}
+
+func TestMaxSizeZero(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "empty.txt"), nil, 0644)
+	os.WriteFile(filepath.Join(dir, "full.txt"), []byte("x"), 0644)
+
+	found, err := scanPaths(dir, Config{RelativePaths: true, HasMaxSize: true})
+	if err != nil {
+		t.Fatalf("scanPaths failed: %v", err)
+	}
+	if strings.Join(found, ",") != "empty.txt" {
+		t.Errorf("Expected only empty.txt, got %v", found)
+	}
+}
+
+func TestCtimePredicate(t *testing.T) {
+	if !ctimeSupported {
+		t.Skip("ctime is not available on this platform")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "touched.txt")
+	os.WriteFile(path, []byte("x"), 0644)
+	// An old mtime does not change the ctime, which is set by the write
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(path, old, old)
+
+	cutoff := time.Now().Add(-time.Hour)
+	for field, expected := range map[string]int{timeFieldMtime: 0, timeFieldCtime: 1} {
+		found, err := scanPaths(dir, Config{NewerThan: cutoff, TimeField: field})
+		if err != nil {
+			t.Fatalf("scanPaths failed: %v", err)
+		}
+		if len(found) != expected {
+			t.Errorf("%s: expected %d files, got %v", field, expected, found)
+		}
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Stats output formats
+const (
+	statsFormatTable = "table"
+	statsFormatJSON  = "json"
+)
+
+var statsFormats = []string{statsFormatTable, statsFormatJSON}
+
+// validateStatsFormat checks that format is a supported stats output format
+func validateStatsFormat(format string) error {
+	if !containsString(statsFormats, format) {
+		return fmt.Errorf("unsupported format %q (supported: %s)", format, strings.Join(statsFormats, ","))
+	}
+	return nil
+}
+
+// defaultStatsTop is how many of the largest files and directories, and of
+// the biggest groups in table output, are listed by default
+const defaultStatsTop = 10
+
+// StatsOptions controls how an inventory or directory is summarized
+type StatsOptions struct {
+	Format string    // table (default) or json
+	Output io.Writer // defaults to os.Stdout
+	Top    int       // largest files and directories to list, defaults to defaultStatsTop
+
+	// Used when scanning a directory
+	IncludeHidden    bool
+	ExcludePatterns  []string
+	IncludePatterns  []string
+	RespectGitignore bool
+}
+
+// sizeBuckets are the upper bounds, exclusive, of the size histogram. The
+// last bucket holds everything larger.
+var sizeBuckets = []int64{1, 1 << 10, 32 << 10, 1 << 20, 32 << 20, 1 << 30, 32 << 30}
+
+// statsGroup counts the files sharing an extension, top-level directory or
+// depth, or the files below a directory
+type statsGroup struct {
+	Name  string `json:"name"`
+	Files int64  `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+type statsDepth struct {
+	Depth int   `json:"depth"` // 1 for files directly in the root
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// statsBucket is a range of the size histogram
+type statsBucket struct {
+	Min   int64  `json:"min"`           // smallest size in the bucket
+	Max   *int64 `json:"max,omitempty"` // exclusive upper bound, absent for the last bucket
+	Files int64  `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+type statsFile struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// inventoryStats summarizes the files of an inventory or directory.
+// Directory entries listed with --dirs are not counted as files.
+type inventoryStats struct {
+	Source      string        `json:"source"`
+	Sizes       bool          `json:"sizes"` // false when the inventory has no size column
+	Files       int64         `json:"files"`
+	Bytes       int64         `json:"bytes"`
+	ByExtension []statsGroup  `json:"by_extension"`
+	ByTopDir    []statsGroup  `json:"by_top_dir"`
+	ByDepth     []statsDepth  `json:"by_depth"`
+	Histogram   []statsBucket `json:"size_histogram,omitempty"`
+	Largest     []statsFile   `json:"largest_files,omitempty"`
+	LargestDirs []statsGroup  `json:"largest_dirs"`
+}
+
+// statsCollector builds inventoryStats one entry at a time, so inventories
+// and scans are summarized without holding every entry in memory
+type statsCollector struct {
+	stats   inventoryStats
+	top     int
+	root    string // prefix stripped from absolute paths, if known
+	byExt   map[string]*statsGroup
+	byTop   map[string]*statsGroup
+	byDepth map[int]*statsDepth
+	dirs    map[string]*statsGroup
+	largest fileHeap
+}
+
+func newStatsCollector(source string, sizes bool, top int) *statsCollector {
+	c := &statsCollector{
+		stats:   inventoryStats{Source: source, Sizes: sizes},
+		top:     top,
+		byExt:   make(map[string]*statsGroup),
+		byTop:   make(map[string]*statsGroup),
+		byDepth: make(map[int]*statsDepth),
+		dirs:    make(map[string]*statsGroup),
+	}
+	if sizes {
+		for i := range sizeBuckets {
+			bucket := statsBucket{Max: &sizeBuckets[i]}
+			if i > 0 {
+				bucket.Min = sizeBuckets[i-1]
+			}
+			c.stats.Histogram = append(c.stats.Histogram, bucket)
+		}
+		c.stats.Histogram = append(c.stats.Histogram, statsBucket{Min: sizeBuckets[len(sizeBuckets)-1]})
+	}
+	return c
+}
+
+// add counts entry
+func (c *statsCollector) add(entry FileEntry) error {
+	if entry.IsDir() {
+		return nil
+	}
+	path := filepath.ToSlash(entry.Path)
+	if c.root != "" {
+		path = strings.TrimPrefix(path, c.root)
+	}
+	path = strings.TrimPrefix(path, "/")
+	size := entry.Size
+
+	c.stats.Files++
+	c.stats.Bytes += size
+	parts := strings.Split(path, "/")
+
+	count := func(groups map[string]*statsGroup, name string) {
+		g := groups[name]
+		if g == nil {
+			g = &statsGroup{Name: name}
+			groups[name] = g
+		}
+		g.Files++
+		g.Bytes += size
+	}
+	count(c.byExt, extensionOf(parts[len(parts)-1]))
+	if len(parts) > 1 {
+		count(c.byTop, parts[0]+dirSuffix)
+	} else {
+		count(c.byTop, ".")
+	}
+	for i := 1; i < len(parts); i++ {
+		count(c.dirs, strings.Join(parts[:i], "/")+dirSuffix)
+	}
+
+	d := c.byDepth[len(parts)]
+	if d == nil {
+		d = &statsDepth{Depth: len(parts)}
+		c.byDepth[len(parts)] = d
+	}
+	d.Files++
+	d.Bytes += size
+
+	if c.stats.Sizes {
+		bucket := sort.Search(len(sizeBuckets), func(i int) bool { return size < sizeBuckets[i] })
+		c.stats.Histogram[bucket].Files++
+		c.stats.Histogram[bucket].Bytes += size
+
+		// Keep the largest files seen so far, smallest on top
+		file := statsFile{Path: path, Bytes: size}
+		if c.largest.Len() < c.top {
+			heap.Push(&c.largest, file)
+		} else if c.top > 0 && file.larger(c.largest[0]) {
+			c.largest[0] = file
+			heap.Fix(&c.largest, 0)
+		}
+	}
+	return nil
+}
+
+// extensionOf returns the lower-cased extension of a file name, or "(none)".
+// The leading dot of hidden files does not start an extension.
+func extensionOf(name string) string {
+	ext := strings.ToLower(filepath.Ext(strings.TrimLeft(name, ".")))
+	if ext == "" {
+		return "(none)"
+	}
+	return ext
+}
+
+// result returns the collected stats with groups sorted largest first
+func (c *statsCollector) result() inventoryStats {
+	stats := c.stats
+	stats.ByExtension = sortedGroups(c.byExt, stats.Sizes)
+	stats.ByTopDir = sortedGroups(c.byTop, stats.Sizes)
+
+	stats.ByDepth = []statsDepth{}
+	for _, d := range c.byDepth {
+		stats.ByDepth = append(stats.ByDepth, *d)
+	}
+	sort.Slice(stats.ByDepth, func(i, j int) bool { return stats.ByDepth[i].Depth < stats.ByDepth[j].Depth })
+
+	stats.LargestDirs = sortedGroups(c.dirs, stats.Sizes)
+	if len(stats.LargestDirs) > c.top {
+		stats.LargestDirs = stats.LargestDirs[:c.top]
+	}
+
+	if stats.Sizes {
+		stats.Largest = append([]statsFile{}, c.largest...)
+		sort.Slice(stats.Largest, func(i, j int) bool { return stats.Largest[i].larger(stats.Largest[j]) })
+	}
+	return stats
+}
+
+// sortedGroups returns groups largest first, by bytes when sizes are known
+// and by file count otherwise, then by name
+func sortedGroups(groups map[string]*statsGroup, sizes bool) []statsGroup {
+	sorted := make([]statsGroup, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, *g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if sizes && a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		if a.Files != b.Files {
+			return a.Files > b.Files
+		}
+		return a.Name < b.Name
+	})
+	return sorted
+}
+
+// larger orders files by size, then by path so that results are stable
+func (f statsFile) larger(other statsFile) bool {
+	if f.Bytes != other.Bytes {
+		return f.Bytes > other.Bytes
+	}
+	return f.Path < other.Path
+}
+
+// fileHeap is a min-heap of files, the smallest on top
+type fileHeap []statsFile
+
+func (h fileHeap) Len() int           { return len(h) }
+func (h fileHeap) Less(i, j int) bool { return h[j].larger(h[i]) }
+func (h fileHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *fileHeap) Push(x any)        { *h = append(*h, x.(statsFile)) }
+
+func (h *fileHeap) Pop() any {
+	old := *h
+	file := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return file
+}
+
+// collectStats summarizes target, which is either a directory to scan or an
+// inventory file
+func collectStats(target string, opts StatsOptions) (inventoryStats, error) {
+	top := opts.Top
+	if top <= 0 {
+		top = defaultStatsTop
+	}
+
+	if info, err := os.Stat(target); err == nil && info.IsDir() {
+		config := Config{
+			RelativePaths:    true,
+			IncludeHidden:    opts.IncludeHidden,
+			ExcludePatterns:  opts.ExcludePatterns,
+			IncludePatterns:  opts.IncludePatterns,
+			RespectGitignore: opts.RespectGitignore,
+			Attributes:       []string{AttrSize},
+		}
+		c := newStatsCollector(target, true, top)
+		if err := scanDirectory(target, config, c.add); err != nil {
+			return inventoryStats{}, fmt.Errorf("failed to scan directory %q: %w", target, err)
+		}
+		return c.result(), nil
+	}
+
+	reader, err := openInventory(target)
+	if err != nil {
+		return inventoryStats{}, fmt.Errorf("error reading %s: %w", target, err)
+	}
+	defer reader.Close()
+
+	c := newStatsCollector(target, containsString(reader.Columns, AttrSize), top)
+	if h := reader.Header; h != nil && !h.Config.RelativePaths && len(h.Roots) == 1 {
+		// Break absolute paths down below the scanned root
+		c.root = strings.TrimSuffix(filepath.ToSlash(h.Roots[0].Path), "/") + "/"
+	}
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return inventoryStats{}, fmt.Errorf("error reading %s: %w", target, err)
+		}
+		c.add(entry)
+	}
+	return c.result(), nil
+}
+
+// runStatsCommand prints a summary of an inventory or directory
+func runStatsCommand(target string, opts StatsOptions) error {
+	if opts.Top <= 0 {
+		opts.Top = defaultStatsTop
+	}
+	if opts.Format == "" {
+		opts.Format = statsFormatTable
+	}
+	if err := validateStatsFormat(opts.Format); err != nil {
+		return err
+	}
+	stats, err := collectStats(target, opts)
+	if err != nil {
+		return err
+	}
+
+	output := opts.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	if opts.Format == statsFormatJSON {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stats)
+	}
+	return renderStatsTable(output, stats, opts.Top)
+}
+
+// statsSection is one titled table of the stats output
+type statsSection struct {
+	title  string
+	header []string
+	rows   [][]string
+}
+
+// renderStatsTable prints the summary as a series of tables. Extensions and
+// top-level directories beyond the top ones are added up in an "(other)" row.
+func renderStatsTable(w io.Writer, stats inventoryStats, top int) error {
+	total := strconv.FormatInt(stats.Files, 10) + " files"
+	if stats.Sizes {
+		total += ", " + formatByteSize(stats.Bytes)
+	}
+	if _, err := fmt.Fprintf(w, "%s: %s\n", stats.Source, total); err != nil {
+		return err
+	}
+	if !stats.Sizes {
+		if _, err := fmt.Fprintln(w, "Sizes are not recorded in this inventory; only file counts are shown."); err != nil {
+			return err
+		}
+	}
+
+	groupRows := func(groups []statsGroup, limit int) [][]string {
+		var rows [][]string
+		other := statsGroup{Name: "(other)"}
+		for i, g := range groups {
+			if limit > 0 && i >= limit {
+				other.Files += g.Files
+				other.Bytes += g.Bytes
+				continue
+			}
+			rows = append(rows, statsCells(stats, g.Name, g.Files, g.Bytes))
+		}
+		if other.Files > 0 {
+			rows = append(rows, statsCells(stats, other.Name, other.Files, other.Bytes))
+		}
+		return rows
+	}
+
+	sections := []statsSection{
+		{"By extension", statsHeader(stats, "extension"), groupRows(stats.ByExtension, top)},
+		{"By top-level directory", statsHeader(stats, "directory"), groupRows(stats.ByTopDir, top)},
+	}
+
+	var depthRows [][]string
+	for _, d := range stats.ByDepth {
+		depthRows = append(depthRows, statsCells(stats, strconv.Itoa(d.Depth), d.Files, d.Bytes))
+	}
+	sections = append(sections, statsSection{"By depth", statsHeader(stats, "depth"), depthRows})
+
+	if stats.Sizes {
+		var histRows [][]string
+		var most int64
+		for _, b := range stats.Histogram {
+			most = max(most, b.Files)
+		}
+		for _, b := range stats.Histogram {
+			bar := ""
+			if most > 0 {
+				bar = strings.Repeat("#", int((b.Files*30+most-1)/most))
+			}
+			histRows = append(histRows, append(statsCells(stats, bucketLabel(b), b.Files, b.Bytes), bar))
+		}
+		var fileRows [][]string
+		for _, f := range stats.Largest {
+			fileRows = append(fileRows, []string{f.Path, formatByteSize(f.Bytes)})
+		}
+		sections = append(sections,
+			statsSection{"Size histogram", append(statsHeader(stats, "range"), ""), histRows},
+			statsSection{"Largest files", []string{"file_path", "size"}, fileRows},
+		)
+	}
+
+	var dirRows [][]string
+	for _, d := range stats.LargestDirs {
+		dirRows = append(dirRows, statsCells(stats, d.Name, d.Files, d.Bytes))
+	}
+	sections = append(sections, statsSection{"Largest directories", statsHeader(stats, "directory"), dirRows})
+
+	for _, section := range sections {
+		if len(section.rows) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "\n%s\n", section.title); err != nil {
+			return err
+		}
+		table := newPlainTable(w)
+		table.Header(section.header)
+		for _, row := range section.rows {
+			table.Append(row)
+		}
+		if err := table.Render(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statsHeader returns the columns of a breakdown table
+func statsHeader(stats inventoryStats, name string) []string {
+	if !stats.Sizes {
+		return []string{name, "files"}
+	}
+	return []string{name, "files", "size", "share"}
+}
+
+// statsCells returns the cells of a breakdown row matching statsHeader.
+// The share is of the total size.
+func statsCells(stats inventoryStats, name string, files, bytes int64) []string {
+	if !stats.Sizes {
+		return []string{name, strconv.FormatInt(files, 10)}
+	}
+	share := "-"
+	if stats.Bytes > 0 {
+		share = strconv.FormatFloat(float64(bytes)*100/float64(stats.Bytes), 'f', 1, 64) + "%"
+	}
+	return []string{name, strconv.FormatInt(files, 10), formatByteSize(bytes), share}
+}
+
+// bucketLabel describes the sizes in a histogram bucket. Buckets are listed
+// in order, so each starts where the previous one ends.
+func bucketLabel(b statsBucket) string {
+	switch {
+	case b.Max == nil:
+		return ">= " + formatByteSize(b.Min)
+	case *b.Max == 1:
+		return "empty"
+	default:
+		return "< " + formatByteSize(*b.Max)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// statsTree creates a small tree and returns its root
+func statsTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]int{
+		"README.md":            100,
+		"src/main.go":          2000,
+		"src/util.go":          500,
+		"src/vendor/lib/x.go":  40000,
+		"docs/guide.MD":        3000,
+		"docs/img/logo.png":    2 << 20,
+		"empty":                0,
+		"docs/img/.hidden.png": 10,
+	}
+	for name, size := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, bytes.Repeat([]byte("x"), size), 0644)
+	}
+	return dir
+}
+
+func groupsByName(groups []statsGroup) map[string]statsGroup {
+	byName := make(map[string]statsGroup)
+	for _, g := range groups {
+		byName[g.Name] = g
+	}
+	return byName
+}
+
+func TestCollectStatsDirectory(t *testing.T) {
+	dir := statsTree(t)
+
+	stats, err := collectStats(dir, StatsOptions{Top: 2})
+	if err != nil {
+		t.Fatalf("collectStats failed: %v", err)
+	}
+	if !stats.Sizes || stats.Files != 7 || stats.Bytes != 100+2000+500+40000+3000+2<<20 {
+		t.Errorf("Unexpected totals: %d files, %d bytes", stats.Files, stats.Bytes)
+	}
+
+	ext := groupsByName(stats.ByExtension)
+	if ext[".go"].Files != 3 || ext[".go"].Bytes != 42500 {
+		t.Errorf("Unexpected .go group: %+v", ext[".go"])
+	}
+	if ext[".md"].Files != 2 || ext["(none)"].Files != 1 {
+		t.Errorf("Extensions should be case-insensitive and default to (none): %+v", stats.ByExtension)
+	}
+	if stats.ByExtension[0].Name != ".png" {
+		t.Errorf("Expected groups sorted by size, got %+v", stats.ByExtension)
+	}
+
+	top := groupsByName(stats.ByTopDir)
+	if top["."].Files != 2 || top["src/"].Files != 3 || top["docs/"].Files != 2 {
+		t.Errorf("Unexpected top-level directories: %+v", stats.ByTopDir)
+	}
+
+	if len(stats.ByDepth) != 4 || stats.ByDepth[0].Files != 2 || stats.ByDepth[1].Files != 3 ||
+		stats.ByDepth[2].Files != 1 || stats.ByDepth[3].Files != 1 {
+		t.Errorf("Unexpected depths: %+v", stats.ByDepth)
+	}
+
+	if stats.Histogram[0].Files != 1 || stats.Histogram[1].Files != 2 || stats.Histogram[3].Files != 1 {
+		t.Errorf("Unexpected histogram: %+v", stats.Histogram)
+	}
+	var histogramFiles int64
+	for _, b := range stats.Histogram {
+		histogramFiles += b.Files
+	}
+	if histogramFiles != stats.Files {
+		t.Errorf("Histogram holds %d files, expected %d", histogramFiles, stats.Files)
+	}
+
+	if len(stats.Largest) != 2 || stats.Largest[0].Path != "docs/img/logo.png" || stats.Largest[1].Path != "src/vendor/lib/x.go" {
+		t.Errorf("Unexpected largest files: %+v", stats.Largest)
+	}
+	if len(stats.LargestDirs) != 2 || stats.LargestDirs[0].Name != "docs/" || stats.LargestDirs[1].Name != "docs/img/" {
+		t.Errorf("Unexpected largest directories: %+v", stats.LargestDirs)
+	}
+}
+
+func TestCollectStatsInventory(t *testing.T) {
+	dir := statsTree(t)
+	withSizes := filepath.Join(t.TempDir(), "sizes.txt.gz")
+	if err := runCreateCommand(dir, withSizes, Config{RelativePaths: true, Attributes: []string{AttrSize}, Dirs: true, Header: true}); err != nil {
+		t.Fatalf("runCreateCommand failed: %v", err)
+	}
+
+	// The inventory gives the same stats as the directory; directories
+	// listed with --dirs are not counted
+	fromDir, _ := collectStats(dir, StatsOptions{})
+	fromInventory, err := collectStats(withSizes, StatsOptions{})
+	if err != nil {
+		t.Fatalf("collectStats failed: %v", err)
+	}
+	fromDir.Source = withSizes
+	a, _ := json.Marshal(fromDir)
+	b, _ := json.Marshal(fromInventory)
+	if string(a) != string(b) {
+		t.Errorf("Expected stats of the inventory to match the directory:\n%s\n%s", a, b)
+	}
+
+	// Absolute paths are broken down below the recorded root
+	full := filepath.Join(t.TempDir(), "full.txt")
+	if err := runCreateCommand(dir, full, Config{Attributes: []string{AttrSize}, Header: true}); err != nil {
+		t.Fatalf("runCreateCommand failed: %v", err)
+	}
+	stats, err := collectStats(full, StatsOptions{})
+	if err != nil {
+		t.Fatalf("collectStats failed: %v", err)
+	}
+	if top := groupsByName(stats.ByTopDir); top["src/"].Files != 3 {
+		t.Errorf("Unexpected top-level directories: %+v", stats.ByTopDir)
+	}
+
+	// Without sizes only counts are reported
+	pathsOnly := filepath.Join(t.TempDir(), "paths.txt")
+	if err := runCreateCommand(dir, pathsOnly, Config{RelativePaths: true}); err != nil {
+		t.Fatalf("runCreateCommand failed: %v", err)
+	}
+	stats, err = collectStats(pathsOnly, StatsOptions{})
+	if err != nil {
+		t.Fatalf("collectStats failed: %v", err)
+	}
+	if stats.Sizes || stats.Files != 7 || stats.Bytes != 0 || stats.Histogram != nil || stats.Largest != nil {
+		t.Errorf("Unexpected stats without sizes: %+v", stats)
+	}
+	if stats.LargestDirs[0].Name != "src/" {
+		t.Errorf("Expected directories ranked by file count, got %+v", stats.LargestDirs)
+	}
+}
+
+func TestRunStatsCommand(t *testing.T) {
+	dir := statsTree(t)
+
+	var out bytes.Buffer
+	if err := runStatsCommand(dir, StatsOptions{Output: &out, Top: 1}); err != nil {
+		t.Fatalf("runStatsCommand failed: %v", err)
+	}
+	output := out.String()
+	for _, want := range []string{"7 files, 2.0 MiB", "By extension", "(other)", "By depth", "Size histogram", "Largest files", "docs/img/logo.png", "Largest directories"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected %q in output:\n%s", want, output)
+		}
+	}
+
+	out.Reset()
+	if err := runStatsCommand(dir, StatsOptions{Output: &out, Format: statsFormatJSON, IncludeHidden: true}); err != nil {
+		t.Fatalf("runStatsCommand failed: %v", err)
+	}
+	var stats inventoryStats
+	if err := json.Unmarshal(out.Bytes(), &stats); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, out.String())
+	}
+	if stats.Files != 8 {
+		t.Errorf("Expected hidden files to be counted, got %d files", stats.Files)
+	}
+
+	if err := runStatsCommand(dir, StatsOptions{Format: "xml"}); err == nil {
+		t.Error("Expected error for unsupported format")
+	}
+	if err := runStatsCommand(filepath.Join(dir, "missing"), StatsOptions{}); err == nil {
+		t.Error("Expected error for missing input")
+	}
+}
+
+func TestExtensionOf(t *testing.T) {
+	tests := map[string]string{
+		"main.go":     ".go",
+		"ARCHIVE.TAR": ".tar",
+		"a.tar.gz":    ".gz",
+		"Makefile":    "(none)",
+		".bashrc":     "(none)",
+		".config.yml": ".yml",
+	}
+	for name, expected := range tests {
+		if got := extensionOf(name); got != expected {
+			t.Errorf("extensionOf(%q) = %q; expected %q", name, got, expected)
+		}
+	}
+}
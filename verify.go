@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// VerifyOptions controls how a directory is checked against an inventory
+type VerifyOptions struct {
+	Output io.Writer // defaults to os.Stdout
+	Quiet  bool      // only compute the result, print nothing
+
+	// Patterns are only recorded in inventories with a header; these are
+	// added to the recorded ones
+	ExcludePatterns  []string
+	IncludePatterns  []string
+	RespectGitignore bool
+	Jobs             int // hashing workers, defaults to the number of CPUs
+}
+
+// statusUnreadable marks verified files with a recorded digest that could
+// not be read again, so their content was not checked
+const statusUnreadable = "unreadable"
+
+// verifyResult is the outcome of checking a directory against an inventory
+type verifyResult struct {
+	Config  Config    // config the directory was re-scanned with
+	Checked int       // entries listed in the inventory
+	Rows    []diffRow // removed rows are missing files, added rows extra ones, unreadable rows unchecked ones
+}
+
+// verifyInventory re-scans roots with the config the inventory was created
+// with and compares the result to it. Recorded hashes are recomputed. With
+// no roots, those recorded in the inventory header are scanned.
+func verifyInventory(inventoryFile string, roots []labeledRoot, opts VerifyOptions) (verifyResult, error) {
+	inv, err := readInventory(inventoryFile)
+	if err != nil {
+		return verifyResult{}, fmt.Errorf("error reading %s: %w", inventoryFile, err)
+	}
+	if roots, err = verifyRoots(inv, roots); err != nil {
+		return verifyResult{}, err
+	}
+
+	config := inventoryConfig(inv)
+	config.ExcludePatterns = append(slices.Clip(config.ExcludePatterns), opts.ExcludePatterns...)
+	config.IncludePatterns = append(slices.Clip(config.IncludePatterns), opts.IncludePatterns...)
+	config.RespectGitignore = config.RespectGitignore || opts.RespectGitignore
+	config.Jobs = opts.Jobs
+
+	// The inventory does not list itself when it lives in the tree
+	if absInventory, err := filepath.Abs(inventoryFile); err == nil {
+		config.Skip = []string{absInventory, tempNameFor(absInventory)}
+	}
+
+	var entries []FileEntry
+	err = scanDirectories(roots, config, func(entry FileEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return verifyResult{}, err
+	}
+
+	scanned := &Inventory{Columns: config.Columns(), Entries: entries}
+	rows := computeDiff(inv, scanned, comparableColumns(inv, scanned))
+	return verifyResult{
+		Config:  config,
+		Checked: len(inv.Entries),
+		Rows:    markUnreadable(rows, inv, scanned),
+	}, nil
+}
+
+// verifyRoots resolves the roots to re-scan for inv, defaulting to the ones
+// recorded in its header. Relative paths of an inventory of several roots
+// start with their labels, so the roots must be labeled the same way.
+func verifyRoots(inv *Inventory, roots []labeledRoot) ([]labeledRoot, error) {
+	if len(roots) == 0 {
+		if inv.Header == nil {
+			return nil, errors.New("the inventory has no header recording its directories; give them as arguments")
+		}
+		roots = inv.Header.Roots
+	}
+	roots, err := resolveRoots(roots)
+	if err != nil {
+		return nil, err
+	}
+
+	if inv.Header != nil && inv.Header.Config.RelativePaths {
+		recorded := make([]string, len(inv.Header.Roots))
+		for i, root := range inv.Header.Roots {
+			recorded[i] = root.Label
+		}
+		given := make([]string, len(roots))
+		for i, root := range roots {
+			given[i] = root.Label
+		}
+		slices.Sort(recorded)
+		slices.Sort(given)
+		if !slices.Equal(recorded, given) {
+			return nil, fmt.Errorf("the inventory was created from %d labeled directories (%s); give each as LABEL=DIR",
+				len(recorded), strings.Join(recorded, ", "))
+		}
+	}
+	return roots, nil
+}
+
+// markUnreadable reports the files whose digest could not be recomputed.
+// Hashes are only compared when both sides have one, so without this a file
+// that cannot be read would pass. Rows already modified keep their changes.
+func markUnreadable(rows []diffRow, inv, scanned *Inventory) []diffRow {
+	recorded := entriesByPath(inv)
+	unreadable := make(map[string]FileEntry)
+	for _, entry := range scanned.Entries {
+		if old, ok := recorded[entry.Path]; ok && old.Hash != "" && entry.Type == "f" && entry.Hash == "" {
+			unreadable[entry.Path] = entry
+		}
+	}
+	if len(unreadable) == 0 {
+		return rows
+	}
+
+	for i, row := range rows {
+		if _, ok := unreadable[row.Path]; ok && row.Status == statusModified {
+			rows[i].Status = statusUnreadable
+			delete(unreadable, row.Path)
+		}
+	}
+	for path, entry := range unreadable {
+		rows = append(rows, diffRow{Path: path, Status: statusUnreadable, oldEntry: recorded[path], newEntry: entry})
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Path < rows[j].Path })
+	return rows
+}
+
+// inventoryConfig returns the config an inventory was created with. It is
+// read from the header when there is one, and worked out from the columns
+// and entries otherwise.
+func inventoryConfig(inv *Inventory) Config {
+	if inv.Header != nil {
+		config := inv.Header.Config.config()
+		config.SortOutput = true
+		return config
+	}
+
+	config := Config{SortOutput: true, RelativePaths: true}
+	for _, col := range inv.Columns {
+		if isKnownAttribute(col) {
+			config.Attributes = append(config.Attributes, col)
+		}
+	}
+	if inv.HasColumn(AttrTarget) {
+		config.Symlinks = symlinksRecord
+	}
+
+	for _, entry := range inv.Entries {
+		if config.HashAlgorithm == "" && entry.Hash != "" {
+			config.HashAlgorithm = hashAlgorithmOf(entry.Hash)
+		}
+		if entry.IsDir() {
+			config.Dirs = true
+		}
+		if filepath.IsAbs(entry.Path) {
+			config.RelativePaths = false
+		}
+		if isHidden(strings.TrimSuffix(entry.Path, dirSuffix)) {
+			config.IncludeHidden = true
+		}
+	}
+	return config
+}
+
+// runVerifyCommandWithRoots checks roots against an inventory, or the roots
+// recorded in it when none are given
+func runVerifyCommandWithRoots(inventoryFile string, roots []labeledRoot, opts VerifyOptions) error {
+	result, err := verifyInventory(inventoryFile, roots, opts)
+	if err != nil {
+		return fmt.Errorf("failed to verify %s: %w", inventoryFile, err)
+	}
+
+	if !opts.Quiet {
+		output := opts.Output
+		if output == nil {
+			output = os.Stdout
+		}
+		if err := renderVerify(output, result); err != nil {
+			return err
+		}
+	}
+
+	if len(result.Rows) > 0 {
+		return errInventoriesDiffer
+	}
+	return nil
+}
+
+// renderVerify prints a line per mismatching entry, like sha256sum -c does
+// for failed files, followed by a summary
+func renderVerify(w io.Writer, result verifyResult) error {
+	var missing, extra, changed, unreadable int
+	for _, row := range result.Rows {
+		var err error
+		switch row.Status {
+		case statusRemoved:
+			missing++
+			_, err = fmt.Fprintf(w, "%s: MISSING\n", row.Path)
+		case statusAdded:
+			extra++
+			_, err = fmt.Fprintf(w, "%s: EXTRA\n", row.Path)
+		case statusModified:
+			changed++
+			_, err = fmt.Fprintf(w, "%s: CHANGED (%s)\n", row.Path, strings.Join(row.Changes, ", "))
+		case statusUnreadable:
+			unreadable++
+			if len(row.Changes) > 0 {
+				_, err = fmt.Fprintf(w, "%s: FAILED open or read (%s)\n", row.Path, strings.Join(row.Changes, ", "))
+			} else {
+				_, err = fmt.Fprintf(w, "%s: FAILED open or read\n", row.Path)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(result.Rows) == 0 {
+		_, err := fmt.Fprintf(w, "OK: %d entries verified\n", result.Checked)
+		return err
+	}
+	summary := fmt.Sprintf("FAILED: %d entries checked, %d missing, %d extra, %d changed", result.Checked, missing, extra, changed)
+	if unreadable > 0 {
+		summary += fmt.Sprintf(", %d unreadable", unreadable)
+	}
+	_, err := fmt.Fprintln(w, summary)
+	return err
+}
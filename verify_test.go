@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeTestInventory scans dir with config and writes the result to an
+// inventory file
+func writeTestInventory(t *testing.T, dir string, config Config) string {
+	t.Helper()
+	entries, err := scanEntries(dir, config)
+	if err != nil {
+		t.Fatalf("scanEntries failed: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "inventory.txt")
+	if err := writeInventory(file, formatText, config.Columns(), entries); err != nil {
+		t.Fatalf("writeInventory failed: %v", err)
+	}
+	return file
+}
+
+func TestInventoryConfig(t *testing.T) {
+	inv := &Inventory{
+		Columns: []string{AttrSize, AttrType, AttrTarget, AttrHash},
+		Entries: []FileEntry{
+			{Path: ".cache/"},
+			{Path: "a.txt", Hash: "sha1:abc"},
+			{Path: "b.txt", Hash: "sha1:def"},
+		},
+	}
+
+	config := inventoryConfig(inv)
+	if strings.Join(config.Attributes, ",") != "size,type" {
+		t.Errorf("Expected attributes size,type, got %v", config.Attributes)
+	}
+	if config.HashAlgorithm != "sha1" || config.Symlinks != symlinksRecord {
+		t.Errorf("Expected sha1 hashes and recorded symlinks, got %+v", config)
+	}
+	if !config.Dirs || !config.IncludeHidden || !config.RelativePaths {
+		t.Errorf("Expected dirs, hidden entries and relative paths, got %+v", config)
+	}
+}
+
+func TestRunVerifyCommand(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("world"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "c.txt"), []byte("!"), 0644)
+
+	config := Config{RelativePaths: true, SortOutput: true, Attributes: []string{AttrSize}, HashAlgorithm: "sha256", Dirs: true}
+	inventory := writeTestInventory(t, dir, config)
+
+	var out bytes.Buffer
+	if err := runVerifyCommandWithRoots(inventory, []labeledRoot{{Path: dir}}, VerifyOptions{Output: &out}); err != nil {
+		t.Fatalf("Expected unchanged directory to verify, got %v", err)
+	}
+	if out.String() != "OK: 4 entries verified\n" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+
+	// Same size, different content: only the hash can tell
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("HELLO"), 0644)
+	os.Remove(filepath.Join(dir, "sub", "c.txt"))
+	os.MkdirAll(filepath.Join(dir, "new"), 0755)
+
+	out.Reset()
+	err := runVerifyCommandWithRoots(inventory, []labeledRoot{{Path: dir}}, VerifyOptions{Output: &out})
+	if !errors.Is(err, errInventoriesDiffer) {
+		t.Fatalf("Expected errInventoriesDiffer, got %v", err)
+	}
+	expected := "a.txt: CHANGED (hash changed)\n" +
+		"new/: EXTRA\n" +
+		"sub/c.txt: MISSING\n" +
+		"FAILED: 4 entries checked, 1 missing, 1 extra, 1 changed\n"
+	if out.String() != expected {
+		t.Errorf("Expected output:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	out.Reset()
+	err = runVerifyCommandWithRoots(inventory, []labeledRoot{{Path: dir}}, VerifyOptions{Output: &out, Quiet: true, ExcludePatterns: []string{"new/"}})
+	if !errors.Is(err, errInventoriesDiffer) || out.Len() != 0 {
+		t.Errorf("Expected a silent mismatch, got %v and %q", err, out.String())
+	}
+}
+
+func TestRunVerifyCommandErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := runVerifyCommandWithRoots(filepath.Join(dir, "missing.txt"), []labeledRoot{{Path: dir}}, VerifyOptions{Quiet: true}); err == nil || errors.Is(err, errInventoriesDiffer) {
+		t.Errorf("Expected error for missing inventory, got %v", err)
+	}
+
+	inventory := writeTestInventory(t, dir, Config{RelativePaths: true})
+	if err := runVerifyCommandWithRoots(inventory, []labeledRoot{{Path: filepath.Join(dir, "nonexistent")}}, VerifyOptions{Quiet: true}); err == nil || errors.Is(err, errInventoriesDiffer) {
+		t.Errorf("Expected error for missing directory, got %v", err)
+	}
+}
+
+func TestRunVerifyCommandWithHeader(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(dir, "build.log"), []byte("log"), 0644)
+
+	// Patterns recorded in the header apply without being given again
+	config := Config{RelativePaths: true, SortOutput: true, ExcludePatterns: []string{"*.log"}, Attributes: []string{AttrSize}}
+	inventory := filepath.Join(t.TempDir(), "inventory.txt")
+	entries, err := scanEntries(dir, config)
+	if err != nil {
+		t.Fatalf("scanEntries failed: %v", err)
+	}
+	if err := writeInventoryWithHeader(inventory, formatText, config.Columns(), newInventoryHeader([]labeledRoot{{Path: dir}}, config), entries); err != nil {
+		t.Fatalf("writeInventoryWithHeader failed: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "other.log"), []byte("log"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.tmp"), []byte("tmp"), 0644)
+
+	var out bytes.Buffer
+	if err := runVerifyCommandWithRoots(inventory, []labeledRoot{{Path: dir}}, VerifyOptions{Output: &out, ExcludePatterns: []string{"*.tmp"}}); err != nil {
+		t.Fatalf("Expected a match, got %v\n%s", err, out.String())
+	}
+	if out.String() != "OK: 1 entries verified\n" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+}
+
+func TestMarkUnreadable(t *testing.T) {
+	recorded := &Inventory{Columns: []string{AttrSize, AttrHash}, Entries: []FileEntry{
+		{Path: "a.txt", Size: 5, Hash: "sha256:aaaa"},
+		{Path: "b.txt", Size: 5, Hash: "sha256:bbbb"},
+		{Path: "c.txt", Size: 5, Hash: "sha256:cccc"},
+		{Path: "d.txt", Size: 5},
+	}}
+	// b.txt and c.txt could not be hashed, c.txt also changed size
+	scanned := &Inventory{Columns: []string{AttrSize, AttrHash}, Entries: []FileEntry{
+		{Path: "a.txt", Size: 5, Type: "f", Hash: "sha256:aaaa"},
+		{Path: "b.txt", Size: 5, Type: "f"},
+		{Path: "c.txt", Size: 6, Type: "f"},
+		{Path: "d.txt", Size: 5, Type: "f"},
+	}}
+
+	rows := markUnreadable(computeDiff(recorded, scanned, comparableColumns(recorded, scanned)), recorded, scanned)
+	var out bytes.Buffer
+	if err := renderVerify(&out, verifyResult{Checked: 4, Rows: rows}); err != nil {
+		t.Fatalf("renderVerify failed: %v", err)
+	}
+	expected := "b.txt: FAILED open or read\n" +
+		"c.txt: FAILED open or read (size 5→6)\n" +
+		"FAILED: 4 entries checked, 0 missing, 0 extra, 0 changed, 2 unreadable\n"
+	if out.String() != expected {
+		t.Errorf("Expected output:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestRunVerifyCommandUnreadableFile(t *testing.T) {
+	if os.Geteuid() == 0 || runtime.GOOS == "windows" {
+		t.Skip("permissions are not enforced")
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("world"), 0644)
+	inventory := writeTestInventory(t, dir, Config{RelativePaths: true, SortOutput: true, HashAlgorithm: "sha256"})
+
+	locked := filepath.Join(dir, "b.txt")
+	os.WriteFile(locked, []byte("WORLD"), 0644)
+	os.Chmod(locked, 0)
+	defer os.Chmod(locked, 0644)
+
+	var out bytes.Buffer
+	err := runVerifyCommandWithRoots(inventory, []labeledRoot{{Path: dir}}, VerifyOptions{Output: &out})
+	if !errors.Is(err, errInventoriesDiffer) {
+		t.Fatalf("Expected errInventoriesDiffer, got %v", err)
+	}
+	expected := "b.txt: FAILED open or read\n" +
+		"FAILED: 2 entries checked, 0 missing, 0 extra, 0 changed, 1 unreadable\n"
+	if out.String() != expected {
+		t.Errorf("Expected output:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestRunVerifyCommandWithRoots(t *testing.T) {
+	base := t.TempDir()
+	app := filepath.Join(base, "d")
+	data := filepath.Join(base, "e")
+	os.MkdirAll(app, 0755)
+	os.MkdirAll(data, 0755)
+	os.WriteFile(filepath.Join(app, "server"), []byte("bin"), 0644)
+	os.WriteFile(filepath.Join(data, "db"), []byte("rows"), 0644)
+
+	inventory := filepath.Join(t.TempDir(), "multi.txt")
+	config := Config{RelativePaths: true, SortOutput: true, HashAlgorithm: "sha256", Header: true}
+	if err := runCreateCommandWithRoots([]labeledRoot{{Label: "app", Path: app}, {Path: data}}, inventory, config); err != nil {
+		t.Fatalf("runCreateCommandWithRoots failed: %v", err)
+	}
+
+	// The recorded roots are used by default
+	var out bytes.Buffer
+	if err := runVerifyCommandWithRoots(inventory, nil, VerifyOptions{Output: &out}); err != nil {
+		t.Fatalf("Expected the recorded roots to verify, got %v\n%s", err, out.String())
+	}
+	if out.String() != "OK: 2 entries verified\n" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+
+	// Roots given again must carry the same labels
+	if err := runVerifyCommandWithRoots(inventory, []labeledRoot{{Label: "app", Path: app}, {Path: data}}, VerifyOptions{Quiet: true}); err != nil {
+		t.Errorf("Expected labeled roots to verify, got %v", err)
+	}
+	err := runVerifyCommandWithRoots(inventory, []labeledRoot{{Path: app}}, VerifyOptions{Quiet: true})
+	if err == nil || errors.Is(err, errInventoriesDiffer) || !strings.Contains(err.Error(), "LABEL=DIR") {
+		t.Errorf("Expected an error asking for labeled roots, got %v", err)
+	}
+
+	os.WriteFile(filepath.Join(data, "db"), []byte("ROWS"), 0644)
+	out.Reset()
+	err = runVerifyCommandWithRoots(inventory, nil, VerifyOptions{Output: &out})
+	if !errors.Is(err, errInventoriesDiffer) || !strings.Contains(out.String(), filepath.Join("e", "db")+": CHANGED (hash changed)") {
+		t.Errorf("Expected e/db to have changed, got %v\n%s", err, out.String())
+	}
+
+	// Without a header there are no recorded roots
+	bare := filepath.Join(t.TempDir(), "bare.txt")
+	if err := runCreateCommand(app, bare, Config{RelativePaths: true}); err != nil {
+		t.Fatalf("runCreateCommand failed: %v", err)
+	}
+	if err := runVerifyCommandWithRoots(bare, nil, VerifyOptions{Quiet: true}); err == nil || errors.Is(err, errInventoriesDiffer) {
+		t.Errorf("Expected an error without directories, got %v", err)
+	}
+}
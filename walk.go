@@ -0,0 +1,458 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// scanner holds the per-scan state shared by the serial and parallel
+// walkers, so both apply exactly the same filtering
+type scanner struct {
+	root        string // absolute scan root
+	config      Config
+	filter      pathFilter
+	ignoreFiles []string
+	predicates  entryPredicates
+	needInfo    bool
+	recordInode bool
+}
+
+func newScanner(root string, config Config) *scanner {
+	predicates := newEntryPredicates(config)
+	return &scanner{
+		root:        root,
+		config:      config,
+		filter:      newPathFilter(config),
+		ignoreFiles: ignoreFileNames(config),
+		predicates:  predicates,
+		needInfo:    len(config.Attributes) > 0 || predicates.active(),
+		recordInode: containsString(config.Attributes, AttrInode),
+	}
+}
+
+// enterDir decides whether the directory at absPath should be walked and
+// returns the ignore rules in effect inside it. The root is always walked.
+func (s *scanner) enterDir(absPath, relPath string, rules *ignoreRules) (*ignoreRules, bool) {
+	matchPath := filepath.ToSlash(relPath)
+	if absPath != s.root && (s.filter.skipDir(matchPath) || rules.ignored(matchPath, true)) {
+		return nil, false
+	}
+	return rules.enterDir(absPath, matchPath, s.ignoreFiles), true
+}
+
+// Symlink handling modes for --symlinks
+const (
+	symlinksSkip   = "skip"   // leave symlinks out of the inventory
+	symlinksRecord = "record" // list symlinks with their target, without following them
+	symlinksFollow = "follow" // descend into linked directories and list linked files
+)
+
+var symlinkModes = []string{symlinksSkip, symlinksRecord, symlinksFollow}
+
+// validateSymlinkMode checks that mode is empty or a supported symlink mode
+func validateSymlinkMode(mode string) error {
+	if mode != "" && !containsString(symlinkModes, mode) {
+		return fmt.Errorf("unsupported symlink mode %q (supported: %s)", mode, strings.Join(symlinkModes, ","))
+	}
+	return nil
+}
+
+// fileID identifies a directory for cycle detection: by device and inode
+// where the platform has them, otherwise by its fully resolved path
+type fileID struct {
+	dev, ino uint64
+	path     string
+}
+
+// dirChain is the list of directories from the root down to a directory
+// being walked, used to detect symlinks that lead back to an ancestor
+type dirChain struct {
+	parent *dirChain
+	id     fileID
+}
+
+func (c *dirChain) contains(id fileID) bool {
+	for ; c != nil; c = c.parent {
+		if c.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// dirJob is a directory waiting to be read
+type dirJob struct {
+	absPath   string
+	relPath   string
+	rules     *ignoreRules
+	ancestors *dirChain // only tracked when following symlinks
+}
+
+// rootJob returns the job for the scan root
+func (s *scanner) rootJob() dirJob {
+	rules, _ := s.enterDir(s.root, ".", nil)
+	job := dirJob{absPath: s.root, relPath: ".", rules: rules}
+	if s.config.Symlinks == symlinksFollow {
+		if info, err := os.Stat(s.root); err == nil {
+			if id, ok := fileIDOf(s.root, info); ok {
+				job.ancestors = &dirChain{id: id}
+			}
+		}
+	}
+	return job
+}
+
+// visitChild handles one entry of the directory being read by job.
+// Directories to walk are passed to descend; other entries that pass the
+// filters are passed to emit.
+func (s *scanner) visitChild(job dirJob, d fs.DirEntry, descend func(dirJob) error, emit func(FileEntry) error) error {
+	absPath := filepath.Join(job.absPath, d.Name())
+	relPath := d.Name()
+	if job.relPath != "." {
+		relPath = filepath.Join(job.relPath, d.Name())
+	}
+
+	// info is the followed target of a symlink, nil otherwise
+	var info fs.FileInfo
+	if d.Type()&fs.ModeSymlink != 0 {
+		switch s.config.Symlinks {
+		case symlinksSkip:
+			return nil
+		case symlinksFollow:
+			info = s.followLink(absPath, job.ancestors)
+		}
+	}
+
+	if d.IsDir() || (info != nil && info.IsDir()) {
+		// Prune excluded directories instead of filtering every file inside
+		inner, ok := s.enterDir(absPath, relPath, job.rules)
+		if !ok {
+			return nil
+		}
+		if s.config.Dirs {
+			if entry, ok := s.visitDir(absPath, relPath, d, info); ok {
+				if err := emit(entry); err != nil {
+					return err
+				}
+			}
+		}
+
+		child := dirJob{absPath: absPath, relPath: relPath, rules: inner, ancestors: job.ancestors}
+		if s.config.Symlinks == symlinksFollow {
+			if info == nil {
+				info, _ = d.Info()
+			}
+			if info != nil {
+				if id, ok := fileIDOf(absPath, info); ok {
+					child.ancestors = &dirChain{parent: job.ancestors, id: id}
+				}
+			}
+		}
+		return descend(child)
+	}
+
+	if entry, ok := s.visitFile(absPath, relPath, d, info, job.rules); ok {
+		return emit(entry)
+	}
+	return nil
+}
+
+// followLink resolves a symlink for follow mode. It returns nil, after
+// reporting why, for dangling links and for links back to a directory on
+// the current path; those are then listed as links.
+func (s *scanner) followLink(absPath string, ancestors *dirChain) fs.FileInfo {
+	info, err := os.Stat(absPath)
+	if err != nil {
+		target, _ := os.Readlink(absPath)
+		fmt.Fprintf(os.Stderr, "Warning: dangling symlink %q -> %q\n", absPath, target)
+		return nil
+	}
+
+	if info.IsDir() {
+		if id, ok := fileIDOf(absPath, info); ok && ancestors.contains(id) {
+			target, _ := os.Readlink(absPath)
+			fmt.Fprintf(os.Stderr, "Warning: not following symlink cycle %q -> %q\n", absPath, target)
+			return nil
+		}
+	}
+	return info
+}
+
+// visitFile applies the filters to a non-directory entry and builds its
+// inventory record. info is the target of a followed symlink, or nil. It
+// returns false when the entry should be left out.
+func (s *scanner) visitFile(absPath, relPath string, d fs.DirEntry, info fs.FileInfo, rules *ignoreRules) (FileEntry, bool) {
+	// Patterns are matched against the slash-separated path relative to the root
+	matchPath := filepath.ToSlash(relPath)
+
+	// Apply ignore files from this directory and its ancestors
+	if rules.ignored(matchPath, false) {
+		return FileEntry{}, false
+	}
+
+	// Skip hidden files if not included
+	if !s.config.IncludeHidden && isHidden(absPath) {
+		return FileEntry{}, false
+	}
+
+	// Never list the inventory being written
+	if containsString(s.config.Skip, absPath) {
+		return FileEntry{}, false
+	}
+
+	// Apply include/exclude patterns
+	if !s.filter.includeFile(matchPath) {
+		return FileEntry{}, false
+	}
+
+	return s.newEntry(absPath, relPath, d, info)
+}
+
+// visitDir applies the filters to a directory that is being walked and
+// builds its inventory record for --dirs. Ignore files and exclude patterns
+// were already applied when deciding to walk it.
+func (s *scanner) visitDir(absPath, relPath string, d fs.DirEntry, info fs.FileInfo) (FileEntry, bool) {
+	// Hidden directories are still walked, as their files are not hidden
+	if !s.config.IncludeHidden && isHidden(absPath) {
+		return FileEntry{}, false
+	}
+	if !s.filter.includeDir(filepath.ToSlash(relPath)) {
+		return FileEntry{}, false
+	}
+	return s.newEntry(absPath, relPath, d, info)
+}
+
+// newEntry builds the inventory record for d. info is the target of a
+// followed symlink, or nil. Directory paths get a trailing slash so they
+// stand out in every format, including plain path lists.
+func (s *scanner) newEntry(absPath, relPath string, d fs.DirEntry, info fs.FileInfo) (FileEntry, bool) {
+	// Convert to relative path if requested
+	finalPath := absPath
+	if s.config.RelativePaths {
+		finalPath = filepath.Join(s.config.Label, relPath)
+	}
+
+	entry := FileEntry{Path: finalPath, Type: fileTypeLetter(d.Type()), srcPath: absPath}
+	if info != nil {
+		entry.Type = fileTypeLetter(info.Mode().Type())
+	}
+	if d.Type()&fs.ModeSymlink != 0 && s.config.Symlinks != "" {
+		// Followed links keep their target too, so the column says where
+		// the listed file was reached through
+		entry.Target = s.readLink(absPath)
+	}
+	if len(s.config.Types) > 0 && !containsString(s.config.Types, entry.Type) {
+		return FileEntry{}, false
+	}
+	if entry.Type == "d" {
+		entry.Path += dirSuffix
+	}
+
+	if s.needInfo {
+		if info == nil {
+			var err error
+			if info, err = d.Info(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: skipping %q: %v\n", absPath, err)
+				return FileEntry{}, false
+			}
+		}
+
+		// Apply size and time predicates
+		if !s.predicates.match(info) {
+			return FileEntry{}, false
+		}
+
+		entry.Size = info.Size()
+		entry.ModTime = info.ModTime()
+		entry.Mode = info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+		if s.recordInode {
+			if id, ok := fileIDOf(absPath, info); ok {
+				entry.Inode = id.ino
+			}
+		}
+	}
+	return entry, true
+}
+
+// readLink returns the target of a symlink being recorded. In record mode
+// dangling links are reported here; follow mode has already reported them.
+func (s *scanner) readLink(absPath string) string {
+	target, err := os.Readlink(absPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot read symlink %q: %v\n", absPath, err)
+		return ""
+	}
+	if s.config.Symlinks == symlinksRecord {
+		if _, err := os.Stat(absPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: dangling symlink %q -> %q\n", absPath, target)
+		}
+	}
+	return target
+}
+
+// walkSerial walks the tree depth-first, calling emit for every included
+// file in lexical order. The walk stops at the first error returned by emit.
+func (s *scanner) walkSerial(emit func(FileEntry) error) error {
+	return s.walkDir(s.rootJob(), emit)
+}
+
+func (s *scanner) walkDir(job dirJob, emit func(FileEntry) error) error {
+	entries, err := os.ReadDir(job.absPath)
+	if err != nil {
+		// Log warning but continue processing
+		fmt.Fprintf(os.Stderr, "Warning: skipping %q: %v\n", job.absPath, err)
+	}
+
+	descend := func(child dirJob) error {
+		return s.walkDir(child, emit)
+	}
+	for _, d := range entries {
+		if err := s.visitChild(job, d, descend, emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirQueue is an unbounded work queue of directories. Workers add the
+// subdirectories they find, so a bounded channel could deadlock.
+type dirQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	jobs    []dirJob
+	pending int  // jobs queued or being processed
+	stopped bool // the walk failed; no more jobs are taken
+}
+
+func newDirQueue() *dirQueue {
+	q := &dirQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *dirQueue) push(job dirJob) {
+	q.mu.Lock()
+	if q.stopped {
+		q.mu.Unlock()
+		return
+	}
+	q.jobs = append(q.jobs, job)
+	q.pending++
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// pop returns the next directory, blocking while other workers may still
+// add more. It returns false once the whole tree has been read.
+func (q *dirQueue) pop() (dirJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.jobs) == 0 && q.pending > 0 {
+		q.cond.Wait()
+	}
+	if len(q.jobs) == 0 {
+		return dirJob{}, false
+	}
+	// Taking the newest job walks depth-first and keeps the queue short
+	job := q.jobs[len(q.jobs)-1]
+	q.jobs = q.jobs[:len(q.jobs)-1]
+	return job, true
+}
+
+// stop drops the queued jobs and any pushed later, so that workers finish
+// the directories they are reading and return
+func (q *dirQueue) stop() {
+	q.mu.Lock()
+	q.stopped = true
+	q.pending -= len(q.jobs)
+	q.jobs = nil
+	finished := q.pending == 0
+	q.mu.Unlock()
+	if finished {
+		q.cond.Broadcast()
+	}
+}
+
+// done marks a popped job as finished
+func (q *dirQueue) done() {
+	q.mu.Lock()
+	q.pending--
+	finished := q.pending == 0
+	q.mu.Unlock()
+	if finished {
+		q.cond.Broadcast()
+	}
+}
+
+// walkParallel reads directories on a pool of workers goroutines and calls
+// emit for every included file. emit is never called concurrently, but the
+// order of entries is not deterministic. The walk stops at the first error
+// returned by emit: queued directories are dropped and none is read after.
+func (s *scanner) walkParallel(workers int, emit func(FileEntry) error) error {
+	queue := newDirQueue()
+	queue.push(s.rootJob())
+
+	var emitMu sync.Mutex
+	var emitErr error
+	safeEmit := func(entry FileEntry) error {
+		emitMu.Lock()
+		defer emitMu.Unlock()
+		if emitErr == nil {
+			if emitErr = emit(entry); emitErr != nil {
+				queue.stop()
+			}
+		}
+		return emitErr
+	}
+	failed := func() bool {
+		emitMu.Lock()
+		defer emitMu.Unlock()
+		return emitErr != nil
+	}
+	descend := func(child dirJob) error {
+		queue.push(child)
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, ok := queue.pop()
+				if !ok {
+					return
+				}
+				// A job popped before the queue was stopped is not read either
+				if !failed() {
+					s.readDir(job, descend, safeEmit)
+				}
+				queue.done()
+			}
+		}()
+	}
+
+	wg.Wait()
+	return emitErr
+}
+
+// readDir lists one directory for the parallel walker, queueing
+// subdirectories and emitting files. It stops early once emit fails.
+func (s *scanner) readDir(job dirJob, descend func(dirJob) error, emit func(FileEntry) error) {
+	entries, err := os.ReadDir(job.absPath)
+	if err != nil {
+		// Log warning but continue processing
+		fmt.Fprintf(os.Stderr, "Warning: skipping %q: %v\n", job.absPath, err)
+	}
+
+	for _, d := range entries {
+		if s.visitChild(job, d, descend, emit) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// makeTree creates a synthetic tree of the given depth where every directory
+// holds fanout subdirectories and filesPerDir files
+func makeTree(tb testing.TB, root string, depth, fanout, filesPerDir int) int {
+	tb.Helper()
+	count := 0
+	for i := 0; i < filesPerDir; i++ {
+		name := filepath.Join(root, fmt.Sprintf("file%03d.dat", i))
+		if err := os.WriteFile(name, []byte(name), 0644); err != nil {
+			tb.Fatalf("Failed to create %s: %v", name, err)
+		}
+		count++
+	}
+	if depth == 0 {
+		return count
+	}
+	for i := 0; i < fanout; i++ {
+		sub := filepath.Join(root, fmt.Sprintf("dir%02d", i))
+		if err := os.Mkdir(sub, 0755); err != nil {
+			tb.Fatalf("Failed to create %s: %v", sub, err)
+		}
+		count += makeTree(tb, sub, depth-1, fanout, filesPerDir)
+	}
+	return count
+}
+
+func TestParallelWalkMatchesSerial(t *testing.T) {
+	dir := t.TempDir()
+	expected := makeTree(t, dir, 3, 4, 5)
+
+	// Add filters so both walkers exercise pruning and ignore files
+	os.WriteFile(filepath.Join(dir, "dir01", inventoryIgnoreFile), []byte("file001.dat\n/dir02/\n"), 0644)
+	os.WriteFile(filepath.Join(dir, ".hidden"), []byte("x"), 0644)
+
+	configs := []Config{
+		{RelativePaths: true, SortOutput: true},
+		{RelativePaths: false, SortOutput: true, ExcludePatterns: []string{"dir03/", "file004.dat"}},
+		{RelativePaths: true, SortOutput: true, IncludeHidden: true, Attributes: []string{AttrSize, AttrType}},
+	}
+
+	for i, config := range configs {
+		t.Run(fmt.Sprintf("config%d", i), func(t *testing.T) {
+			serial, err := scanEntries(dir, config)
+			if err != nil {
+				t.Fatalf("serial walk failed: %v", err)
+			}
+			if i == 0 && len(serial) >= expected {
+				t.Errorf("Expected the ignore file to drop entries, got %d of %d", len(serial), expected)
+			}
+
+			for _, walkers := range []int{2, 4, 16} {
+				config.Walkers = walkers
+				parallel, err := scanEntries(dir, config)
+				if err != nil {
+					t.Fatalf("parallel walk failed: %v", err)
+				}
+				if len(parallel) != len(serial) {
+					t.Fatalf("walkers=%d: expected %d entries, got %d", walkers, len(serial), len(parallel))
+				}
+				for j := range serial {
+					if serial[j].Path != parallel[j].Path || serial[j].Size != parallel[j].Size || serial[j].Type != parallel[j].Type {
+						t.Fatalf("walkers=%d: entry %d differs: %+v vs %+v", walkers, j, serial[j], parallel[j])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParallelWalkUnreadableDirectory(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "ok.txt"), []byte("x"), 0644)
+	locked := filepath.Join(dir, "locked")
+	os.Mkdir(locked, 0755)
+	os.WriteFile(filepath.Join(locked, "secret.txt"), []byte("x"), 0644)
+	os.Chmod(locked, 0)
+	defer os.Chmod(locked, 0755)
+
+	files, err := scanPaths(dir, Config{RelativePaths: true, Walkers: 4})
+	if err != nil {
+		t.Fatalf("Unreadable directories should be skipped, got error: %v", err)
+	}
+	if strings.Join(files, ",") != "ok.txt" {
+		t.Errorf("Expected only ok.txt, got %v", files)
+	}
+}
+
+func TestParallelWalkStopsAtEmitError(t *testing.T) {
+	dir := t.TempDir()
+	makeTree(t, dir, 3, 4, 5)
+
+	root, err := scanRoot(dir)
+	if err != nil {
+		t.Fatalf("scanRoot failed: %v", err)
+	}
+	errStop := errors.New("stop")
+	calls := 0
+	err = newScanner(root, Config{RelativePaths: true}).walkParallel(4, func(FileEntry) error {
+		calls++
+		return errStop
+	})
+	if err != errStop || calls != 1 {
+		t.Errorf("Expected the walk to stop at the first error, got %v after %d calls", err, calls)
+	}
+}
+
+func TestDirQueueStop(t *testing.T) {
+	queue := newDirQueue()
+	queue.push(dirJob{absPath: "a"})
+	queue.push(dirJob{absPath: "b"})
+	if _, ok := queue.pop(); !ok {
+		t.Fatal("Expected a job")
+	}
+
+	// Queued jobs are dropped and later ones ignored, so the walk ends once
+	// the job being read is done
+	queue.stop()
+	queue.push(dirJob{absPath: "c"})
+	queue.done()
+	if job, ok := queue.pop(); ok {
+		t.Errorf("Expected no job after stop, got %+v", job)
+	}
+}
+
+func TestSymlinkModes(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("b"), 0644)
+	links := map[string]string{
+		"file.lnk":     "a.txt",
+		"dir.lnk":      "sub",
+		"dangling.lnk": "missing.txt",
+		"sub/loop.lnk": "..",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+
+	tests := []struct {
+		mode     string
+		expected []string // path:type:target
+	}{
+		{"", []string{"a.txt:f:", "dangling.lnk:l:", "dir.lnk:l:", "file.lnk:l:", "sub/b.txt:f:", "sub/loop.lnk:l:"}},
+		{symlinksSkip, []string{"a.txt:f:", "sub/b.txt:f:"}},
+		{symlinksRecord, []string{"a.txt:f:", "dangling.lnk:l:missing.txt", "dir.lnk:l:sub", "file.lnk:l:a.txt", "sub/b.txt:f:", "sub/loop.lnk:l:.."}},
+		{symlinksFollow, []string{
+			// Links back to the root are cycles and stay listed as links
+			"a.txt:f:", "dangling.lnk:l:missing.txt", "dir.lnk/b.txt:f:", "dir.lnk/loop.lnk:l:..",
+			"file.lnk:f:a.txt", "sub/b.txt:f:", "sub/loop.lnk:l:..",
+		}},
+	}
+
+	for _, tt := range tests {
+		for _, walkers := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%s/walkers=%d", tt.mode, walkers), func(t *testing.T) {
+				config := Config{RelativePaths: true, SortOutput: true, Symlinks: tt.mode, Walkers: walkers}
+				entries, err := scanEntries(dir, config)
+				if err != nil {
+					t.Fatalf("scanEntries failed: %v", err)
+				}
+
+				var got []string
+				for _, e := range entries {
+					got = append(got, filepath.ToSlash(e.Path)+":"+e.Type+":"+e.Target)
+				}
+				if strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+					t.Errorf("Expected\n  %v\ngot\n  %v", tt.expected, got)
+				}
+			})
+		}
+	}
+}
+
+func benchmarkWalk(b *testing.B, walkers int) {
+	dir := b.TempDir()
+	makeTree(b, dir, 3, 8, 10)
+	s := newScanner(dir, Config{RelativePaths: true})
+	discard := func(FileEntry) error { return nil }
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if walkers > 1 {
+			err = s.walkParallel(walkers, discard)
+		} else {
+			err = s.walkSerial(discard)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWalkSerial(b *testing.B)     { benchmarkWalk(b, 1) }
+func BenchmarkWalkParallel4(b *testing.B)  { benchmarkWalk(b, 4) }
+func BenchmarkWalkParallel16(b *testing.B) { benchmarkWalk(b, 16) }
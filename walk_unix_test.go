@@ -0,0 +1,52 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestFindEntriesWithTypes(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "sub", "a.txt"), []byte("x"), 0644)
+	if err := unix.Mkfifo(filepath.Join(dir, "sub", "pipe"), 0644); err != nil {
+		t.Skipf("fifos not supported: %v", err)
+	}
+	if err := os.Symlink("sub/a.txt", filepath.Join(dir, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	tests := []struct {
+		types    []string
+		expected []string
+	}{
+		{nil, []string{"link:l", "sub/a.txt:f", "sub/pipe:p"}},
+		{[]string{"f"}, []string{"sub/a.txt:f"}},
+		{[]string{"f", "l"}, []string{"link:l", "sub/a.txt:f"}},
+		{[]string{"p"}, []string{"sub/pipe:p"}},
+		{[]string{"s", "b", "c"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.types, ","), func(t *testing.T) {
+			config := Config{RelativePaths: true, SortOutput: true, Types: tt.types}
+			entries, err := scanEntries(dir, config)
+			if err != nil {
+				t.Fatalf("scanEntries failed: %v", err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, filepath.ToSlash(e.Path)+":"+e.Type)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
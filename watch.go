@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WatchOptions controls how a live inventory is kept up to date
+type WatchOptions struct {
+	Changes bool          // print a line per entry that appears, disappears or changes
+	Output  io.Writer     // where changes and progress go, defaults to os.Stdout
+	Settle  time.Duration // how long to collect events before updating, 0 for the default
+}
+
+// defaultSettle is how long events are collected before the inventory is
+// updated, so that a burst of writes results in a single rewrite
+const defaultSettle = 250 * time.Millisecond
+
+// dirWatcher reports changes inside the directories added to it. It is
+// implemented with inotify on Linux.
+type dirWatcher interface {
+	// Add starts watching the entries of a directory, not recursively
+	Add(dir string) error
+	// Events delivers changes; the channel is closed when the watcher stops
+	Events() <-chan watchEvent
+	// Err returns the reason the watcher stopped, if it failed
+	Err() error
+	Close() error
+}
+
+// watchEvent is a change to an entry of a watched directory, or to the
+// directory itself
+type watchEvent struct {
+	Path     string // absolute path of the entry that changed
+	Overflow bool   // events were lost; everything must be rescanned
+}
+
+// liveInventory holds the entries of a watched tree in memory and applies
+// changes to them directory by directory. Directories are read with the same
+// scanner as create, so filtering is identical.
+type liveInventory struct {
+	scanner *scanner
+	watcher dirWatcher
+	output  string        // inventory file, kept out of the inventory itself
+	skip    []string      // absolute paths of the inventory file and its temporary copy
+	hashing *hashPipeline // hashes files on --jobs workers during a full scan, or nil
+
+	entries map[string]FileEntry // by absolute source path
+	dirs    map[string]dirJob    // walked directories by absolute path
+
+	// Entries replaced or added since the last update, for reporting changes
+	replaced map[string]FileEntry
+	added    map[string]bool
+}
+
+func newLiveInventory(root, output string, config Config, watcher dirWatcher) (*liveInventory, error) {
+	absOutput, err := filepath.Abs(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %q: %w", output, err)
+	}
+	return &liveInventory{
+		scanner:  newScanner(root, config),
+		watcher:  watcher,
+		output:   output,
+		skip:     []string{absOutput, tempNameFor(absOutput)},
+		entries:  make(map[string]FileEntry),
+		dirs:     make(map[string]dirJob),
+		replaced: make(map[string]FileEntry),
+		added:    make(map[string]bool),
+	}, nil
+}
+
+// scan reads the whole tree. Files are hashed in a pipeline, as by create;
+// entries are recorded on its goroutine while directories are read here.
+func (l *liveInventory) scan() {
+	l.forget(l.scanner.root)
+	if algo := l.scanner.config.HashAlgorithm; algo != "" {
+		l.hashing = newHashPipeline(algo, l.scanner.config.Jobs, nil, l.record)
+		defer func() {
+			l.hashing.close()
+			l.hashing = nil
+		}()
+	}
+	l.readDir(l.scanner.rootJob())
+}
+
+// readDir watches and reads the directory of job, walking subdirectories
+// that have not been walked yet
+func (l *liveInventory) readDir(job dirJob) {
+	// Watch before reading, so that nothing created in between is missed
+	if err := l.watcher.Add(job.absPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot watch %q: %v\n", job.absPath, err)
+	}
+	l.dirs[job.absPath] = job
+
+	entries, err := os.ReadDir(job.absPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: skipping directory %q: %v\n", job.absPath, err)
+		return
+	}
+	for _, d := range entries {
+		l.scanner.visitChild(job, d, l.descend, l.add)
+	}
+}
+
+// descend walks a subdirectory unless it is walked already
+func (l *liveInventory) descend(child dirJob) error {
+	if _, ok := l.dirs[child.absPath]; !ok {
+		l.readDir(child)
+	}
+	return nil
+}
+
+// add records an entry found while reading a directory
+func (l *liveInventory) add(entry FileEntry) error {
+	if containsString(l.skip, entry.srcPath) {
+		return nil
+	}
+	if l.hashing != nil {
+		return l.hashing.add(entry)
+	}
+	if algo := l.scanner.config.HashAlgorithm; algo != "" && entry.Type == "f" {
+		entry = hashEntry(entry, algo)
+	}
+	return l.record(entry)
+}
+
+// record stores an entry once it is hashed
+func (l *liveInventory) record(entry FileEntry) error {
+	if _, ok := l.replaced[entry.srcPath]; !ok {
+		l.added[entry.srcPath] = true
+	}
+	l.entries[entry.srcPath] = entry
+	return nil
+}
+
+// remove drops an entry, remembering it to report what changed
+func (l *liveInventory) remove(path string) {
+	if entry, ok := l.entries[path]; ok {
+		if !l.added[path] {
+			l.replaced[path] = entry
+		}
+		delete(l.added, path)
+		delete(l.entries, path)
+	}
+}
+
+// forget drops everything below dir, which is no longer walked
+func (l *liveInventory) forget(dir string) {
+	prefix := dir + string(filepath.Separator)
+	for path := range l.entries {
+		if strings.HasPrefix(path, prefix) {
+			l.remove(path)
+		}
+	}
+	for path := range l.dirs {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			delete(l.dirs, path)
+		}
+	}
+}
+
+// update applies a batch of events. Each changed entry is looked at again
+// on its own; when an ignore file changed, the subtree it applies to is read
+// again, and when events were lost, the whole tree is.
+func (l *liveInventory) update(events []watchEvent) {
+	changed := make(map[string]bool)
+	reread := make(map[string]bool)
+	for _, ev := range events {
+		if ev.Overflow {
+			l.scan()
+			return
+		}
+		dir := filepath.Dir(ev.Path)
+		if containsString(l.scanner.ignoreFiles, filepath.Base(ev.Path)) {
+			reread[dir] = true
+		}
+		changed[ev.Path] = true
+	}
+
+	// Parents first, so that directories a parent drops are not read
+	for _, dir := range sortedKeys(reread) {
+		if _, ok := l.dirs[dir]; !ok {
+			continue
+		}
+		if job, ok := l.rejoin(dir); ok {
+			l.forget(dir)
+			l.readDir(job)
+		}
+	}
+	for _, path := range sortedKeys(changed) {
+		if !reread[filepath.Dir(path)] {
+			l.refresh(path)
+		}
+	}
+}
+
+// refresh looks at a single entry of a walked directory again
+func (l *liveInventory) refresh(path string) {
+	job, ok := l.dirs[filepath.Dir(path)]
+	if !ok {
+		return
+	}
+	l.remove(path)
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		// Gone; if it was a directory, so is everything below it
+		l.forget(path)
+		return
+	}
+	walked := false
+	l.scanner.visitChild(job, fs.FileInfoToDirEntry(info), func(child dirJob) error {
+		walked = true
+		return l.descend(child)
+	}, l.add)
+	if !walked {
+		// Not a directory (any more), or one that is now excluded
+		l.forget(path)
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// rejoin builds the job for a walked directory again, reloading its ignore
+// files. It returns false, after forgetting the directory, when its
+// parent's rules now exclude it.
+func (l *liveInventory) rejoin(dir string) (dirJob, bool) {
+	s := l.scanner
+	if dir == s.root {
+		return s.rootJob(), true
+	}
+
+	parent := l.dirs[filepath.Dir(dir)]
+	relPath, err := filepath.Rel(s.root, dir)
+	if err != nil {
+		return dirJob{}, false
+	}
+	rules, ok := s.enterDir(dir, relPath, parent.rules)
+	if !ok {
+		l.forget(dir)
+		return dirJob{}, false
+	}
+	job := l.dirs[dir]
+	job.rules = rules
+	return job, true
+}
+
+// changes returns a line per entry that appeared ("+"), disappeared ("-")
+// or changed ("~") since the last call, sorted by path
+func (l *liveInventory) changes() []string {
+	columns := l.scanner.config.Columns()
+	var lines []string
+	for path, old := range l.replaced {
+		entry, ok := l.entries[path]
+		if !ok {
+			lines = append(lines, "- "+old.Path)
+		} else if changes := compareEntries(old, entry, columns); len(changes) > 0 {
+			lines = append(lines, "~ "+entry.Path+" ("+strings.Join(changes, ", ")+")")
+		}
+	}
+	for path := range l.added {
+		lines = append(lines, "+ "+l.entries[path].Path)
+	}
+	clear(l.replaced)
+	clear(l.added)
+
+	sort.Slice(lines, func(i, j int) bool { return lines[i][2:] < lines[j][2:] })
+	return lines
+}
+
+// write replaces the inventory file with the current entries, sorted by
+// path. The new inventory is written next to it and renamed into place, so
+// readers never see a partial file.
+func (l *liveInventory) write() error {
+	entries := make([]FileEntry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	config := l.scanner.config
+	var header *inventoryHeader
+	if config.Header {
+		// Entries are always written sorted
+		config.SortOutput = true
+		header = newInventoryHeader([]labeledRoot{{Path: l.scanner.root}}, config)
+	}
+	temp := tempNameFor(l.output)
+	if err := writeInventoryWithHeader(temp, config.Format, config.Columns(), header, entries); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return os.Rename(temp, l.output)
+}
+
+// runWatchCommand creates an inventory of dirPath and keeps it up to date
+// until ctx is cancelled
+func runWatchCommand(ctx context.Context, dirPath, output string, config Config, opts WatchOptions) error {
+	root, err := scanRoot(dirPath)
+	if err != nil {
+		return fmt.Errorf("failed to scan directory %q: %w", dirPath, err)
+	}
+	watcher, err := newDirWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	return watchInventory(ctx, root, output, config, opts, watcher)
+}
+
+// watchInventory does the work of runWatchCommand with the given watcher
+func watchInventory(ctx context.Context, root, output string, config Config, opts WatchOptions, watcher dirWatcher) error {
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	settle := opts.Settle
+	if settle <= 0 {
+		settle = defaultSettle
+	}
+
+	live, err := newLiveInventory(root, output, config, watcher)
+	if err != nil {
+		return err
+	}
+	live.scan()
+	live.changes()
+	if err := live.write(); err != nil {
+		return fmt.Errorf("failed to write inventory to %q: %w", output, err)
+	}
+	fmt.Fprintf(out, "Inventory written to %s\n", output)
+	fmt.Fprintf(out, "Total files found: %d\n", len(live.entries))
+	fmt.Fprintf(out, "Watching %s for changes\n", root)
+
+	var pending []watchEvent
+	var flush <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case ev, ok := <-watcher.Events():
+			if !ok {
+				if err := watcher.Err(); err != nil {
+					return fmt.Errorf("watching %q failed: %w", root, err)
+				}
+				return nil
+			}
+			pending = append(pending, ev)
+			if flush == nil {
+				flush = time.After(settle)
+			}
+
+		case <-flush:
+			live.update(pending)
+			pending, flush = nil, nil
+
+			lines := live.changes()
+			if len(lines) == 0 {
+				continue
+			}
+			if err := live.write(); err != nil {
+				return fmt.Errorf("failed to write inventory to %q: %w", output, err)
+			}
+			if opts.Changes {
+				for _, line := range lines {
+					fmt.Fprintln(out, line)
+				}
+			}
+		}
+	}
+}
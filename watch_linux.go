@@ -0,0 +1,141 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask selects the events that can change an inventory entry
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// inotifyWatcher is a dirWatcher backed by an inotify instance
+type inotifyWatcher struct {
+	fd     int
+	f      *os.File // fd, for reads through the runtime poller
+	events chan watchEvent
+
+	mu      sync.Mutex
+	dirs    map[int32][]string // watch descriptor → directories, several when reached through symlinks
+	err     error
+	closing bool
+}
+
+func newDirWatcher() (dirWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start inotify: %w", err)
+	}
+	// A non-blocking descriptor is handled by the runtime poller, so that
+	// Close interrupts a pending Read. Fd is not used after this, as it
+	// would switch the descriptor back to blocking mode.
+	w := &inotifyWatcher{
+		fd:     fd,
+		f:      os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan watchEvent, 256),
+		dirs:   make(map[int32][]string),
+	}
+	go w.read()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask|syscall.IN_ONLYDIR)
+	if err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			return fmt.Errorf("%w (raise fs.inotify.max_user_watches)", err)
+		}
+		return err
+	}
+	// A directory reached through a symlink alias gets the watch descriptor
+	// it already had; its events are reported under every path
+	w.mu.Lock()
+	if !containsString(w.dirs[int32(wd)], dir) {
+		w.dirs[int32(wd)] = append(w.dirs[int32(wd)], dir)
+	}
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *inotifyWatcher) Events() <-chan watchEvent {
+	return w.events
+}
+
+func (w *inotifyWatcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *inotifyWatcher) Close() error {
+	w.mu.Lock()
+	w.closing = true
+	w.mu.Unlock()
+	return w.f.Close()
+}
+
+// read decodes events until the watcher is closed or fails
+func (w *inotifyWatcher) read() {
+	defer close(w.events)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			w.mu.Lock()
+			if !w.closing {
+				w.err = err
+			}
+			w.mu.Unlock()
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			offset = nameStart + int(raw.Len)
+			for _, ev := range w.decode(raw, buf[nameStart:offset]) {
+				w.events <- ev
+			}
+		}
+	}
+}
+
+// decode turns a raw inotify event into a watchEvent for every path of the
+// watched directory
+func (w *inotifyWatcher) decode(raw *syscall.InotifyEvent, name []byte) []watchEvent {
+	if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+		return []watchEvent{{Overflow: true}}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	dirs, ok := w.dirs[raw.Wd]
+	if !ok {
+		return nil
+	}
+	if raw.Mask&syscall.IN_IGNORED != 0 {
+		// The directory was removed or unmounted, taking its watch with it
+		delete(w.dirs, raw.Wd)
+		return nil
+	}
+
+	// The name is padded with NUL bytes; events about the directory itself
+	// have none
+	for len(name) > 0 && name[len(name)-1] == 0 {
+		name = name[:len(name)-1]
+	}
+	events := make([]watchEvent, len(dirs))
+	for i, dir := range dirs {
+		events[i] = watchEvent{Path: filepath.Join(dir, string(name))}
+	}
+	return events
+}
@@ -0,0 +1,84 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInotifyWatcher(t *testing.T) {
+	dir := t.TempDir()
+	watcher, err := newDirWatcher()
+	if err != nil {
+		t.Skipf("inotify not available: %v", err)
+	}
+	if err := watcher.Add(dir); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	path := filepath.Join(dir, "new.txt")
+	os.WriteFile(path, []byte("x"), 0644)
+
+	select {
+	case ev := <-watcher.Events():
+		if ev.Path != path || ev.Overflow {
+			t.Errorf("Expected an event for %s, got %+v", path, ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an event")
+	}
+
+	// Close stops the reader, which closes the channel without an error
+	if err := watcher.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	for range watcher.Events() {
+	}
+	if err := watcher.Err(); err != nil {
+		t.Errorf("Expected no error after Close, got %v", err)
+	}
+}
+
+func TestWatchInventorySymlinkAlias(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "real"), 0755)
+	if err := os.Symlink("real", filepath.Join(dir, "alias")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	watcher, err := newDirWatcher()
+	if err != nil {
+		t.Skipf("inotify not available: %v", err)
+	}
+	defer watcher.Close()
+
+	// Both paths share a watch descriptor, and both must see the new file
+	output := filepath.Join(t.TempDir(), "inventory.txt")
+	config := Config{RelativePaths: true, Symlinks: symlinksFollow}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- watchInventory(ctx, dir, output, config, WatchOptions{Output: io.Discard, Settle: time.Millisecond}, watcher)
+	}()
+
+	waitFor(t, func() bool {
+		_, err := os.Stat(output)
+		return err == nil
+	})
+	os.WriteFile(filepath.Join(dir, "real", "new.txt"), []byte("x"), 0644)
+	waitFor(t, func() bool {
+		lines, _ := readFileLines(output)
+		_, viaAlias := lines["alias/new.txt"]
+		_, viaReal := lines["real/new.txt"]
+		return viaAlias && viaReal
+	})
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("watchInventory failed: %v", err)
+	}
+}
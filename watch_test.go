@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWatcher records watched directories; tests deliver events by hand
+type fakeWatcher struct {
+	mu     sync.Mutex
+	dirs   []string
+	events chan watchEvent
+}
+
+func newFakeWatcher() *fakeWatcher {
+	return &fakeWatcher{events: make(chan watchEvent, 16)}
+}
+
+func (w *fakeWatcher) Add(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dirs = append(w.dirs, dir)
+	return nil
+}
+
+func (w *fakeWatcher) Events() <-chan watchEvent { return w.events }
+func (w *fakeWatcher) Err() error                { return nil }
+func (w *fakeWatcher) Close() error              { return nil }
+
+// livePaths returns the paths in a live inventory, sorted
+func livePaths(l *liveInventory) string {
+	var paths []string
+	for _, entry := range l.entries {
+		paths = append(paths, filepath.ToSlash(entry.Path))
+	}
+	sort.Strings(paths)
+	return strings.Join(paths, ",")
+}
+
+func TestLiveInventoryUpdate(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("b"), 0644)
+
+	config := Config{RelativePaths: true, Attributes: []string{AttrSize}, Dirs: true}
+	watcher := newFakeWatcher()
+	live, err := newLiveInventory(dir, filepath.Join(dir, "inventory.txt"), config, watcher)
+	if err != nil {
+		t.Fatalf("newLiveInventory failed: %v", err)
+	}
+	live.scan()
+	live.changes()
+	if got := livePaths(live); got != "a.txt,sub/,sub/b.txt" {
+		t.Fatalf("Unexpected initial entries: %s", got)
+	}
+	if len(watcher.dirs) != 2 {
+		t.Errorf("Expected the root and sub to be watched, got %v", watcher.dirs)
+	}
+
+	tests := []struct {
+		name    string
+		change  func()
+		events  []string // paths relative to dir
+		paths   string
+		changes []string
+	}{
+		{
+			name:    "file modified",
+			change:  func() { os.WriteFile(filepath.Join(dir, "a.txt"), []byte("aaa"), 0644) },
+			events:  []string{"a.txt"},
+			paths:   "a.txt,sub/,sub/b.txt",
+			changes: []string{"~ a.txt (size 1→3)"},
+		},
+		{
+			name: "directory created with files",
+			change: func() {
+				os.MkdirAll(filepath.Join(dir, "new", "deep"), 0755)
+				os.WriteFile(filepath.Join(dir, "new", "deep", "c.txt"), []byte("c"), 0644)
+			},
+			events:  []string{"new"},
+			paths:   "a.txt,new/,new/deep/,new/deep/c.txt,sub/,sub/b.txt",
+			changes: []string{"+ new/", "+ new/deep/", "+ new/deep/c.txt"},
+		},
+		{
+			name:    "directory removed",
+			change:  func() { os.RemoveAll(filepath.Join(dir, "sub")) },
+			events:  []string{"sub/b.txt", "sub"},
+			paths:   "a.txt,new/,new/deep/,new/deep/c.txt",
+			changes: []string{"- sub/", "- sub/b.txt"},
+		},
+		{
+			name:    "ignore file added",
+			change:  func() { os.WriteFile(filepath.Join(dir, "new", inventoryIgnoreFile), []byte("deep/\n"), 0644) },
+			events:  []string{"new/" + inventoryIgnoreFile},
+			paths:   "a.txt,new/",
+			changes: []string{"- new/deep/", "- new/deep/c.txt"},
+		},
+		{
+			name:   "inventory file itself",
+			change: func() { os.WriteFile(filepath.Join(dir, "inventory.txt"), []byte("x"), 0644) },
+			events: []string{"inventory.txt"},
+			paths:  "a.txt,new/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change()
+			var events []watchEvent
+			for _, path := range tt.events {
+				events = append(events, watchEvent{Path: filepath.Join(dir, filepath.FromSlash(path))})
+			}
+			live.update(events)
+
+			if got := livePaths(live); got != tt.paths {
+				t.Errorf("Expected entries %s, got %s", tt.paths, got)
+			}
+			if got := live.changes(); strings.Join(got, "|") != strings.Join(tt.changes, "|") {
+				t.Errorf("Expected changes %q, got %q", tt.changes, got)
+			}
+		})
+	}
+}
+
+func TestLiveInventoryOverflow(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+
+	live, err := newLiveInventory(dir, filepath.Join(t.TempDir(), "inventory.txt"), Config{RelativePaths: true}, newFakeWatcher())
+	if err != nil {
+		t.Fatalf("newLiveInventory failed: %v", err)
+	}
+	live.scan()
+	live.changes()
+
+	os.Remove(filepath.Join(dir, "a.txt"))
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0644)
+	live.update([]watchEvent{{Overflow: true}})
+
+	if got := strings.Join(live.changes(), "|"); got != "- a.txt|+ b.txt" {
+		t.Errorf("Unexpected changes after overflow: %s", got)
+	}
+}
+
+func TestWatchInventory(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	output := filepath.Join(t.TempDir(), "inventory.txt")
+
+	watcher := newFakeWatcher()
+	ctx, cancel := context.WithCancel(context.Background())
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- watchInventory(ctx, dir, output, Config{RelativePaths: true}, WatchOptions{Changes: true, Output: &out, Settle: time.Millisecond}, watcher)
+	}()
+
+	// Wait for the initial inventory before changing anything
+	waitFor(t, func() bool {
+		data, _ := os.ReadFile(output)
+		return string(data) == "a.txt\n"
+	})
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0644)
+	watcher.events <- watchEvent{Path: filepath.Join(dir, "b.txt")}
+	waitFor(t, func() bool {
+		data, _ := os.ReadFile(output)
+		return string(data) == "a.txt\nb.txt\n"
+	})
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("watchInventory failed: %v", err)
+	}
+	if !strings.HasSuffix(out.String(), "+ b.txt\n") {
+		t.Errorf("Expected the change stream to list b.txt, got %q", out.String())
+	}
+	if _, err := os.Stat(output + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected no temporary inventory left behind, got %v", err)
+	}
+}
+
+// waitFor polls cond for up to five seconds
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLiveInventoryScanHashes(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 50; i++ {
+		os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%02d.txt", i)), []byte(strings.Repeat("x", i)), 0644)
+	}
+
+	// The scan hashes on --jobs workers; later updates hash on their own
+	config := Config{RelativePaths: true, HashAlgorithm: "sha256", Jobs: 4}
+	live, err := newLiveInventory(dir, filepath.Join(t.TempDir(), "inventory.txt"), config, newFakeWatcher())
+	if err != nil {
+		t.Fatalf("newLiveInventory failed: %v", err)
+	}
+	live.scan()
+	if live.hashing != nil {
+		t.Error("Expected the pipeline to be closed after the scan")
+	}
+	if got := len(live.changes()); got != 50 {
+		t.Errorf("Expected 50 new entries, got %d", got)
+	}
+
+	changed := filepath.Join(dir, "f00.txt")
+	os.WriteFile(changed, []byte("changed"), 0644)
+	live.update([]watchEvent{{Path: changed}})
+
+	for path, entry := range live.entries {
+		want, err := hashFile(path, "sha256")
+		if err != nil {
+			t.Fatalf("hashFile failed: %v", err)
+		}
+		if entry.Hash != want {
+			t.Errorf("%s: expected %s, got %q", entry.Path, want, entry.Hash)
+		}
+	}
+}